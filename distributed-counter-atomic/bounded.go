@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrOutOfBounds = errors.New("counter value out of bounds")
+
+// BoundedCounter is a Counter that never leaves [min, max]. Every update is a
+// compare-and-swap loop, so it can be used as an exact, lock-free inventory
+// or semaphore: TryAdd(-1) takes a unit and TryAdd(1) returns it.
+type BoundedCounter struct {
+	counter *Counter
+	min     int64
+	max     int64
+}
+
+// NewBoundedCounter returns a counter starting at initial that is kept
+// within [min, max].
+func NewBoundedCounter(min, max, initial int64) (*BoundedCounter, error) {
+	if min > max {
+		return nil, fmt.Errorf("invalid bounds: min %d is greater than max %d", min, max)
+	}
+	if initial < min || initial > max {
+		return nil, fmt.Errorf("initial value %d outside [%d, %d]: %w", initial, min, max, ErrOutOfBounds)
+	}
+
+	counter := NewCounter()
+	counter.Swap(initial)
+	return &BoundedCounter{counter: counter, min: min, max: max}, nil
+}
+
+// Get returns the current value of the counter
+func (b *BoundedCounter) Get() int64 {
+	return b.counter.Get()
+}
+
+// Bounds returns the configured minimum and maximum
+func (b *BoundedCounter) Bounds() (int64, int64) {
+	return b.min, b.max
+}
+
+// Counter returns the underlying counter so it can be registered for metrics.
+// Writing to it directly bypasses the bounds.
+func (b *BoundedCounter) Counter() *Counter {
+	return b.counter
+}
+
+// TryAdd adds delta only if the result stays within bounds. It returns the
+// new value and true, or the value it observed and false.
+func (b *BoundedCounter) TryAdd(delta int64) (int64, bool) {
+	for {
+		current := b.counter.Get()
+		next := current + delta
+		// Also reject int64 overflow, which would otherwise wrap into range.
+		if (delta > 0 && next < current) || (delta < 0 && next > current) {
+			return current, false
+		}
+		if next < b.min || next > b.max {
+			return current, false
+		}
+		if b.counter.CompareAndSwap(current, next) {
+			return next, true
+		}
+	}
+}
+
+// TryIncrement increases the counter by 1 unless it is already at max
+func (b *BoundedCounter) TryIncrement() (int64, bool) {
+	return b.TryAdd(1)
+}
+
+// TryDecrement decreases the counter by 1 unless it is already at min
+func (b *BoundedCounter) TryDecrement() (int64, bool) {
+	return b.TryAdd(-1)
+}
+
+// CompareAndSwap sets the counter to new if it currently holds old and new is within bounds
+func (b *BoundedCounter) CompareAndSwap(old, new int64) bool {
+	if new < b.min || new > b.max {
+		return false
+	}
+	return b.counter.CompareAndSwap(old, new)
+}
+
+// Swap stores val and returns the previous value, failing if val is out of bounds
+func (b *BoundedCounter) Swap(val int64) (int64, error) {
+	if val < b.min || val > b.max {
+		return b.counter.Get(), fmt.Errorf("swap to %d outside [%d, %d]: %w", val, b.min, b.max, ErrOutOfBounds)
+	}
+	return b.counter.Swap(val), nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestNewBoundedCounterChecksArgs(t *testing.T) {
+	tests := []struct {
+		min, max, initial int64
+		ok                bool
+	}{
+		{0, 10, 5, true},
+		{0, 0, 0, true},
+		{10, 0, 5, false},
+		{0, 10, 11, false},
+		{0, 10, -1, false},
+	}
+	for _, tt := range tests {
+		_, err := NewBoundedCounter(tt.min, tt.max, tt.initial)
+		if (err == nil) != tt.ok {
+			t.Errorf("NewBoundedCounter(%d, %d, %d) err = %v, want ok %v", tt.min, tt.max, tt.initial, err, tt.ok)
+		}
+	}
+}
+
+func TestBoundedCounterTryAdd(t *testing.T) {
+	tests := []struct {
+		name              string
+		min, max, initial int64
+		delta             int64
+		want              int64
+		ok                bool
+	}{
+		{"within bounds", 0, 10, 5, 3, 8, true},
+		{"up to max", 0, 10, 5, 5, 10, true},
+		{"past max", 0, 10, 5, 6, 5, false},
+		{"down to min", 0, 10, 5, -5, 0, true},
+		{"past min", 0, 10, 5, -6, 5, false},
+		{"overflow", math.MinInt64, math.MaxInt64, math.MaxInt64, 1, math.MaxInt64, false},
+		{"underflow", math.MinInt64, math.MaxInt64, math.MinInt64, -1, math.MinInt64, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := NewBoundedCounter(tt.min, tt.max, tt.initial)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := b.TryAdd(tt.delta)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("TryAdd(%d) = %d, %v; want %d, %v", tt.delta, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestBoundedCounterSwapAndCAS(t *testing.T) {
+	b, _ := NewBoundedCounter(0, 10, 5)
+	if _, err := b.Swap(11); !errors.Is(err, ErrOutOfBounds) {
+		t.Errorf("Swap(11) err = %v, want ErrOutOfBounds", err)
+	}
+	if old, err := b.Swap(7); err != nil || old != 5 {
+		t.Errorf("Swap(7) = %d, %v; want 5, nil", old, err)
+	}
+	if b.CompareAndSwap(7, 20) {
+		t.Error("CompareAndSwap to 20 succeeded outside bounds")
+	}
+	if b.CompareAndSwap(6, 8) {
+		t.Error("CompareAndSwap from a stale value succeeded")
+	}
+	if !b.CompareAndSwap(7, 8) || b.Get() != 8 {
+		t.Errorf("CompareAndSwap(7, 8) left %d", b.Get())
+	}
+}
+
+func TestBoundedCounterNeverOversells(t *testing.T) {
+	b, _ := NewBoundedCounter(0, 100, 100)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	taken := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if _, ok := b.TryDecrement(); !ok {
+					return
+				}
+				mu.Lock()
+				taken++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if taken != 100 || b.Get() != 0 {
+		t.Errorf("took %d units leaving %d, want 100 leaving 0", taken, b.Get())
+	}
+}
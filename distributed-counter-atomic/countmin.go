@@ -0,0 +1,291 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrSketchMismatch = errors.New("count-min: sketches have different dimensions")
+
+type HeavyHitter struct {
+	Key   string
+	Count uint64
+}
+
+// CountMinSketch estimates per-key counts in fixed memory. Estimates never
+// undercount; with probability 1-delta they overcount by at most
+// epsilon * (total of all counts). Cells are updated atomically so Add is
+// safe from many goroutines, and a small heap tracks the top-K keys.
+type CountMinSketch struct {
+	width uint64
+	depth uint64
+	cells []uint64 // depth rows of width counters
+	total uint64
+
+	mu    sync.Mutex
+	k     int
+	top   hitterHeap
+	index map[string]*hitterItem
+}
+
+// NewCountMinSketch sizes a sketch for the given error bound and failure
+// probability that also tracks the k heaviest keys. For example epsilon
+// 0.001 and delta 0.01 give 2719 columns by 5 rows.
+func NewCountMinSketch(epsilon, delta float64, k int) (*CountMinSketch, error) {
+	if epsilon <= 0 || epsilon >= 1 {
+		return nil, fmt.Errorf("count-min epsilon %v must be in (0, 1)", epsilon)
+	}
+	if delta <= 0 || delta >= 1 {
+		return nil, fmt.Errorf("count-min delta %v must be in (0, 1)", delta)
+	}
+	if k < 0 {
+		return nil, fmt.Errorf("count-min top-k size %d must not be negative", k)
+	}
+
+	width := uint64(math.Ceil(math.E / epsilon))
+	depth := uint64(math.Ceil(math.Log(1 / delta)))
+	return &CountMinSketch{
+		width: width,
+		depth: depth,
+		cells: make([]uint64, width*depth),
+		k:     k,
+		index: make(map[string]*hitterItem),
+	}, nil
+}
+
+// Dimensions returns the number of columns and rows in the sketch
+func (s *CountMinSketch) Dimensions() (uint64, uint64) {
+	return s.width, s.depth
+}
+
+// Add counts n occurrences of key and returns its new estimate
+func (s *CountMinSketch) Add(key string, n uint64) uint64 {
+	h1, h2 := cmHashes(key)
+	estimate := uint64(math.MaxUint64)
+	for row := uint64(0); row < s.depth; row++ {
+		cell := &s.cells[row*s.width+(h1+row*h2)%s.width]
+		if v := atomic.AddUint64(cell, n); v < estimate {
+			estimate = v
+		}
+	}
+	atomic.AddUint64(&s.total, n)
+
+	if s.k > 0 {
+		s.mu.Lock()
+		s.track(key, estimate)
+		s.mu.Unlock()
+	}
+	return estimate
+}
+
+// Increment counts one occurrence of key
+func (s *CountMinSketch) Increment(key string) uint64 {
+	return s.Add(key, 1)
+}
+
+// Estimate returns the approximate count for key
+func (s *CountMinSketch) Estimate(key string) uint64 {
+	h1, h2 := cmHashes(key)
+	estimate := uint64(math.MaxUint64)
+	for row := uint64(0); row < s.depth; row++ {
+		if v := atomic.LoadUint64(&s.cells[row*s.width+(h1+row*h2)%s.width]); v < estimate {
+			estimate = v
+		}
+	}
+	return estimate
+}
+
+// Total returns the sum of all counts added
+func (s *CountMinSketch) Total() uint64 {
+	return atomic.LoadUint64(&s.total)
+}
+
+// TopK returns the tracked heavy hitters, largest first
+func (s *CountMinSketch) TopK() []HeavyHitter {
+	s.mu.Lock()
+	hitters := make([]HeavyHitter, len(s.top))
+	for i, item := range s.top {
+		hitters[i] = HeavyHitter{Key: item.key, Count: item.count}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(hitters, func(i, j int) bool {
+		if hitters[i].Count != hitters[j].Count {
+			return hitters[i].Count > hitters[j].Count
+		}
+		return hitters[i].Key < hitters[j].Key
+	})
+	return hitters
+}
+
+// Reset clears every count and the heavy hitters
+func (s *CountMinSketch) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.cells {
+		atomic.StoreUint64(&s.cells[i], 0)
+	}
+	atomic.StoreUint64(&s.total, 0)
+	s.top = nil
+	s.index = make(map[string]*hitterItem)
+}
+
+// Decay multiplies every count by factor (between 0 and 1), so older
+// traffic fades instead of disappearing at a window boundary.
+func (s *CountMinSketch) Decay(factor float64) {
+	if factor < 0 || factor > 1 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.cells {
+		decayCell(&s.cells[i], factor)
+	}
+	decayCell(&s.total, factor)
+	for _, item := range s.top {
+		item.count = uint64(float64(item.count) * factor)
+	}
+	heap.Init(&s.top)
+}
+
+// decayCell scales one counter without losing adds that race with it.
+func decayCell(cell *uint64, factor float64) {
+	for {
+		current := atomic.LoadUint64(cell)
+		if atomic.CompareAndSwapUint64(cell, current, uint64(float64(current)*factor)) {
+			return
+		}
+	}
+}
+
+// RunWindow decays the sketch by factor every interval until the returned
+// stop function is called. A factor of 0 resets it each window.
+func (s *CountMinSketch) RunWindow(interval time.Duration, factor float64) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if factor == 0 {
+					s.Reset()
+				} else {
+					s.Decay(factor)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+}
+
+// Merge adds other's counts into s. Both sketches must have been created
+// with the same epsilon and delta. Heavy hitters from both sides are
+// re-ranked against the merged counts.
+func (s *CountMinSketch) Merge(other *CountMinSketch) error {
+	if s.width != other.width || s.depth != other.depth {
+		return fmt.Errorf("merge %dx%d into %dx%d: %w", other.width, other.depth, s.width, s.depth, ErrSketchMismatch)
+	}
+
+	for i := range other.cells {
+		atomic.AddUint64(&s.cells[i], atomic.LoadUint64(&other.cells[i]))
+	}
+	atomic.AddUint64(&s.total, other.Total())
+
+	if s.k == 0 {
+		return nil
+	}
+	candidates := other.TopK()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range s.top {
+		candidates = append(candidates, HeavyHitter{Key: item.key})
+	}
+	for _, candidate := range candidates {
+		s.track(candidate.Key, s.Estimate(candidate.Key))
+	}
+	return nil
+}
+
+// track updates key's place in the top-K heap. Callers must hold s.mu.
+func (s *CountMinSketch) track(key string, count uint64) {
+	if item, ok := s.index[key]; ok {
+		if count > item.count {
+			item.count = count
+			heap.Fix(&s.top, item.position)
+		}
+		return
+	}
+	if len(s.top) < s.k {
+		item := &hitterItem{key: key, count: count}
+		heap.Push(&s.top, item)
+		s.index[key] = item
+		return
+	}
+	if smallest := s.top[0]; count > smallest.count {
+		delete(s.index, smallest.key)
+		smallest.key = key
+		smallest.count = count
+		s.index[key] = smallest
+		heap.Fix(&s.top, 0)
+	}
+}
+
+// cmHashes derives the two hashes used for double hashing across rows.
+func cmHashes(key string) (uint64, uint64) {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(key))
+	sum := hasher.Sum64()
+	// Two rows share a column only when the step times their distance is a
+	// multiple of width. Forcing the step odd rules that out for widths that
+	// are powers of two; for other widths it only makes it unlikely.
+	return mix64(sum), mix64(sum^0x9e3779b97f4a7c15) | 1
+}
+
+type hitterItem struct {
+	key      string
+	count    uint64
+	position int
+}
+
+// hitterHeap is a min-heap on count so the weakest heavy hitter is evicted first.
+type hitterHeap []*hitterItem
+
+func (h hitterHeap) Len() int           { return len(h) }
+func (h hitterHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h hitterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].position = i
+	h[j].position = j
+}
+
+func (h *hitterHeap) Push(x interface{}) {
+	item := x.(*hitterItem)
+	item.position = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *hitterHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
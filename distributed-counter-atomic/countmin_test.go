@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestNewCountMinSketchChecksArgs(t *testing.T) {
+	tests := []struct {
+		epsilon, delta float64
+		k              int
+		ok             bool
+	}{
+		{0.001, 0.01, 10, true},
+		{0, 0.01, 10, false},
+		{1, 0.01, 10, false},
+		{0.001, 0, 10, false},
+		{0.001, 1, 10, false},
+		{0.001, 0.01, -1, false},
+	}
+	for _, tt := range tests {
+		_, err := NewCountMinSketch(tt.epsilon, tt.delta, tt.k)
+		if (err == nil) != tt.ok {
+			t.Errorf("NewCountMinSketch(%v, %v, %d) err = %v, want ok %v", tt.epsilon, tt.delta, tt.k, err, tt.ok)
+		}
+	}
+}
+
+func TestCountMinSketchBounds(t *testing.T) {
+	sketch, err := NewCountMinSketch(0.01, 0.01, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]uint64)
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("key-%d", i%500)
+		n := uint64(1)
+		if i%500 < 3 {
+			n = 100 // three heavy hitters
+		}
+		sketch.Add(key, n)
+		counts[key] += n
+	}
+
+	slack := uint64(0.01 * float64(sketch.Total()))
+	for key, want := range counts {
+		got := sketch.Estimate(key)
+		if got < want {
+			t.Fatalf("Estimate(%s) = %d undercounts %d", key, got, want)
+		}
+		if got > want+slack*5 {
+			t.Errorf("Estimate(%s) = %d, far above %d", key, got, want)
+		}
+	}
+
+	top := sketch.TopK()
+	if len(top) != 3 {
+		t.Fatalf("TopK() = %v, want 3 hitters", top)
+	}
+	for _, hitter := range top {
+		if counts[hitter.Key] != 400 {
+			t.Errorf("TopK() has %s with true count %d", hitter.Key, counts[hitter.Key])
+		}
+	}
+}
+
+func TestCountMinSketchMerge(t *testing.T) {
+	a, _ := NewCountMinSketch(0.01, 0.01, 2)
+	b, _ := NewCountMinSketch(0.01, 0.01, 2)
+	a.Add("x", 5)
+	b.Add("x", 7)
+	b.Add("y", 20)
+
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Estimate("x"); got < 12 {
+		t.Errorf("Estimate(x) = %d after merge, want at least 12", got)
+	}
+	if a.Total() != 32 {
+		t.Errorf("Total() = %d, want 32", a.Total())
+	}
+	if top := a.TopK(); len(top) != 2 || top[0].Key != "y" {
+		t.Errorf("TopK() = %v, want y first", top)
+	}
+
+	other, _ := NewCountMinSketch(0.1, 0.01, 2)
+	if err := a.Merge(other); !errors.Is(err, ErrSketchMismatch) {
+		t.Errorf("merging different sizes: err = %v, want ErrSketchMismatch", err)
+	}
+}
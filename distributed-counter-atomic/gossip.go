@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// gossipState is the message exchanged between nodes. Every node keeps the
+// number of increments and decrements it has seen from each node id, which
+// makes the state a PN-counter: merging two states is a per-id max, so nodes
+// converge no matter how often or in what order messages arrive.
+//
+// Entries are keyed by incarnation ("id#nonce") rather than by node id: a
+// node that restarts with an empty count starts a new entry, so writes it
+// takes before hearing from its peers add to its old total instead of being
+// absorbed by it.
+type gossipState struct {
+	From string           `json:"from"`
+	Incs map[string]int64 `json:"incs"`
+	Decs map[string]int64 `json:"decs"`
+}
+
+type GossipNode struct {
+	id       string
+	key      string // id#nonce, this incarnation's entry in the state
+	addr     string
+	peers    []string
+	interval time.Duration
+
+	// The node's own increments and decrements stay on the atomic Counter so
+	// the hot path never takes a lock.
+	incs *Counter
+	decs *Counter
+
+	mu         sync.RWMutex
+	remoteIncs map[string]int64
+	remoteDecs map[string]int64
+	syncedAt   map[string]time.Time // last time a peer reported a state equal to ours
+	blocked    map[string]bool      // peers we pretend we can't reach (partitions)
+	lastChange atomic.Int64         // unix nanos of the last local or merged change
+	conn       *net.UDPConn
+	stop       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewGossipNode returns a node identified by id that listens on addr (e.g.
+// "127.0.0.1:7001") and gossips with the given peer addresses every interval.
+func NewGossipNode(id, addr string, peers []string, interval time.Duration) *GossipNode {
+	return &GossipNode{
+		id:         id,
+		key:        fmt.Sprintf("%s#%016x", id, rand.Uint64()),
+		addr:       addr,
+		peers:      peers,
+		interval:   interval,
+		incs:       NewCounter(),
+		decs:       NewCounter(),
+		remoteIncs: make(map[string]int64),
+		remoteDecs: make(map[string]int64),
+		syncedAt:   make(map[string]time.Time),
+		blocked:    make(map[string]bool),
+	}
+}
+
+// Start opens the UDP socket and begins receiving and gossiping.
+func (n *GossipNode) Start() error {
+	udpAddr, err := net.ResolveUDPAddr("udp", n.addr)
+	if err != nil {
+		return fmt.Errorf("invalid gossip address %s: %w", n.addr, err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %w", n.addr, err)
+	}
+
+	n.conn = conn
+	n.stop = make(chan struct{})
+	n.wg.Add(2)
+	go n.receiveLoop()
+	go n.gossipLoop()
+	return nil
+}
+
+// Stop closes the socket and waits for the background goroutines to exit.
+// The node can be restarted with Start; a fresh node with the same id gets a
+// new incarnation and picks up its old count from its peers.
+func (n *GossipNode) Stop() {
+	if n.conn == nil {
+		return
+	}
+	close(n.stop)
+	n.conn.Close()
+	n.wg.Wait()
+	n.conn = nil
+}
+
+// Increment atomically increases the node's share of the counter by 1
+func (n *GossipNode) Increment() {
+	n.incs.Increment()
+	n.touch()
+}
+
+// Decrement atomically decreases the node's share of the counter by 1
+func (n *GossipNode) Decrement() {
+	n.decs.Increment()
+	n.touch()
+}
+
+// AddValue adds val (which may be negative) to the node's share of the counter
+func (n *GossipNode) AddValue(val int64) {
+	if val >= 0 {
+		n.incs.AddValue(val)
+	} else {
+		n.decs.AddValue(-val)
+	}
+	n.touch()
+}
+
+// Get returns the node's current view of the cluster-wide value
+func (n *GossipNode) Get() int64 {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	total := n.incs.Get() - n.decs.Get()
+	for _, v := range n.remoteIncs {
+		total += v
+	}
+	for _, v := range n.remoteDecs {
+		total -= v
+	}
+	return total
+}
+
+// Partition drops all traffic to and from peer until Heal is called.
+func (n *GossipNode) Partition(peer string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.blocked[peer] = true
+}
+
+// Heal restores traffic with a previously partitioned peer.
+func (n *GossipNode) Heal(peer string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.blocked, peer)
+}
+
+// ConvergenceLag reports how long the node's latest change has gone without
+// being confirmed by every peer. It is zero once all peers hold the same state.
+func (n *GossipNode) ConvergenceLag() time.Duration {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	last := n.lastChange.Load()
+	if last == 0 {
+		return 0
+	}
+	changed := time.Unix(0, last)
+	var lag time.Duration
+	for _, peer := range n.peers {
+		if synced, ok := n.syncedAt[peer]; ok && !synced.Before(changed) {
+			continue
+		}
+		if d := time.Since(changed); d > lag {
+			lag = d
+		}
+	}
+	return lag
+}
+
+func (n *GossipNode) touch() {
+	n.lastChange.Store(time.Now().UnixNano())
+}
+
+// snapshot copies the full PN-counter state, including the node's own entry.
+// Callers must hold n.mu.
+func (n *GossipNode) snapshot() gossipState {
+	state := gossipState{
+		From: n.addr,
+		Incs: make(map[string]int64, len(n.remoteIncs)+1),
+		Decs: make(map[string]int64, len(n.remoteDecs)+1),
+	}
+	for id, v := range n.remoteIncs {
+		state.Incs[id] = v
+	}
+	for id, v := range n.remoteDecs {
+		state.Decs[id] = v
+	}
+	state.Incs[n.key] = n.incs.Get()
+	state.Decs[n.key] = n.decs.Get()
+	return state
+}
+
+func (n *GossipNode) gossipLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+			n.gossipOnce()
+		}
+	}
+}
+
+// gossipOnce sends the node's state to one random reachable peer.
+func (n *GossipNode) gossipOnce() {
+	if len(n.peers) == 0 {
+		return
+	}
+	peer := n.peers[rand.Intn(len(n.peers))]
+
+	n.mu.RLock()
+	if n.blocked[peer] {
+		n.mu.RUnlock()
+		return
+	}
+	state := n.snapshot()
+	n.mu.RUnlock()
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	peerAddr, err := net.ResolveUDPAddr("udp", peer)
+	if err != nil {
+		return
+	}
+	// Send errors are expected while a peer is down; the next round retries.
+	n.conn.WriteToUDP(payload, peerAddr)
+}
+
+func (n *GossipNode) receiveLoop() {
+	defer n.wg.Done()
+	buf := make([]byte, 64*1024)
+
+	for {
+		size, _, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-n.stop:
+				return
+			default:
+				continue
+			}
+		}
+
+		var state gossipState
+		if err := json.Unmarshal(buf[:size], &state); err != nil {
+			continue
+		}
+		n.merge(state)
+	}
+}
+
+// merge folds a peer's state into ours by taking the per-id maximum.
+func (n *GossipNode) merge(state gossipState) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.blocked[state.From] {
+		return
+	}
+
+	changed := false
+	for key, v := range state.Incs {
+		if n.mergeEntry(key, v, n.remoteIncs) {
+			changed = true
+		}
+	}
+	for key, v := range state.Decs {
+		if n.mergeEntry(key, v, n.remoteDecs) {
+			changed = true
+		}
+	}
+	if changed {
+		n.touch()
+	}
+
+	if sameState(n.snapshot(), state) {
+		n.syncedAt[state.From] = time.Now()
+	}
+}
+
+// mergeEntry applies a single remote entry. Our own entry is never behind a
+// peer's copy of it, since only this incarnation writes to it.
+func (n *GossipNode) mergeEntry(key string, v int64, remote map[string]int64) bool {
+	if key == n.key {
+		return false
+	}
+	if v > remote[key] {
+		remote[key] = v
+		return true
+	}
+	return false
+}
+
+func sameState(a, b gossipState) bool {
+	return sameEntries(a.Incs, b.Incs) && sameEntries(a.Decs, b.Decs)
+}
+
+// sameEntries compares two maps treating missing ids as zero.
+func sameEntries(a, b map[string]int64) bool {
+	for id, v := range a {
+		if b[id] != v {
+			return false
+		}
+	}
+	for id, v := range b {
+		if a[id] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGossipMerge(t *testing.T) {
+	a := gossipState{From: "a", Incs: map[string]int64{"a#1": 5, "b#1": 2}, Decs: map[string]int64{"a#1": 1}}
+	b := gossipState{From: "b", Incs: map[string]int64{"a#1": 3, "b#1": 4}, Decs: map[string]int64{"b#1": 2}}
+	tests := []struct {
+		name   string
+		states []gossipState
+		want   int64
+	}{
+		{"one state", []gossipState{a}, 7 + 5 + 2 - 1},
+		{"per-id max", []gossipState{a, b}, 7 + 5 + 4 - 1 - 2},
+		{"order does not matter", []gossipState{b, a}, 7 + 5 + 4 - 1 - 2},
+		{"duplicates are harmless", []gossipState{a, b, a, b}, 7 + 5 + 4 - 1 - 2},
+		{"own entry is ours alone", []gossipState{{From: "x", Incs: map[string]int64{"self#1": 100}}}, 7},
+		{"blocked peers are dropped", []gossipState{{From: "blocked", Incs: map[string]int64{"c#1": 100}}}, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The node itself holds 7.
+			node := NewGossipNode("self", "self", nil, time.Second)
+			node.key = "self#1"
+			node.AddValue(7)
+			node.Partition("blocked")
+			for _, state := range tt.states {
+				node.merge(state)
+			}
+			if got := node.Get(); got != tt.want {
+				t.Errorf("Get() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGossipConvergenceLagBeforeChanges(t *testing.T) {
+	node := NewGossipNode("a", "a", []string{"b"}, time.Second)
+	if lag := node.ConvergenceLag(); lag != 0 {
+		t.Errorf("ConvergenceLag() = %s before any change, want 0", lag)
+	}
+}
+
+// freeUDPAddrs reserves n loopback UDP addresses.
+func freeUDPAddrs(t *testing.T, n int) []string {
+	t.Helper()
+	var addrs []string
+	for i := 0; i < n; i++ {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, conn.LocalAddr().String())
+		conn.Close()
+	}
+	return addrs
+}
+
+func startGossipNode(t *testing.T, id string, addrs []string, i int) *GossipNode {
+	t.Helper()
+	var peers []string
+	for j, addr := range addrs {
+		if j != i {
+			peers = append(peers, addr)
+		}
+	}
+	node := NewGossipNode(id, addrs[i], peers, 10*time.Millisecond)
+	if err := node.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(node.Stop)
+	return node
+}
+
+func TestGossipRestartKeepsWrites(t *testing.T) {
+	addrs := freeUDPAddrs(t, 3)
+	ids := []string{"a", "b", "c"}
+	nodes := make([]*GossipNode, 3)
+	for i, id := range ids {
+		nodes[i] = startGossipNode(t, id, addrs, i)
+	}
+	for _, node := range nodes {
+		node.AddValue(3)
+	}
+	waitFor(t, "the first writes to spread", func() bool { return nodes[2].Get() == 9 })
+
+	// The restarted node starts from zero and writes before it has heard
+	// from anyone; its new writes must add to the old ones.
+	nodes[0].Stop()
+	nodes[0] = startGossipNode(t, "a", addrs, 0)
+	nodes[0].AddValue(2)
+
+	waitFor(t, "every node to converge on 11", func() bool {
+		for _, node := range nodes {
+			if node.Get() != 11 {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sync/atomic"
+)
+
+const (
+	hllMinPrecision = 4
+	hllMaxPrecision = 18
+	hllVersion      = 1
+)
+
+var ErrPrecisionMismatch = errors.New("hyperloglog: sketches have different precision")
+
+// HyperLogLog estimates the number of distinct items added to it using
+// 2^precision small registers. The standard error is about 1.04/sqrt(2^precision),
+// so precision 14 uses 16K registers for roughly 0.8% error. Registers are
+// updated with compare-and-swap, so Add is safe to call concurrently.
+type HyperLogLog struct {
+	precision uint8
+	registers []uint32
+}
+
+// NewHyperLogLog returns a sketch with the given precision (between 4 and 18).
+func NewHyperLogLog(precision uint8) (*HyperLogLog, error) {
+	if precision < hllMinPrecision || precision > hllMaxPrecision {
+		return nil, fmt.Errorf("hyperloglog precision %d outside [%d, %d]", precision, hllMinPrecision, hllMaxPrecision)
+	}
+	return &HyperLogLog{
+		precision: precision,
+		registers: make([]uint32, 1<<precision),
+	}, nil
+}
+
+// Precision returns the number of index bits the sketch was created with
+func (h *HyperLogLog) Precision() uint8 {
+	return h.precision
+}
+
+// Add records item in the sketch
+func (h *HyperLogLog) Add(item []byte) {
+	hash := hllHash(item)
+	index := hash >> (64 - h.precision)
+	// The rank is the position of the first 1 bit in the remaining bits;
+	// the sentinel bit caps it when they are all zero.
+	rest := hash<<h.precision | 1<<(h.precision-1)
+	rank := uint32(bits.LeadingZeros64(rest) + 1)
+	h.raise(index, rank)
+}
+
+// AddString records a string item in the sketch
+func (h *HyperLogLog) AddString(item string) {
+	h.Add([]byte(item))
+}
+
+// raise lifts register index to at least rank.
+func (h *HyperLogLog) raise(index uint64, rank uint32) {
+	register := &h.registers[index]
+	for {
+		current := atomic.LoadUint32(register)
+		if current >= rank {
+			return
+		}
+		if atomic.CompareAndSwapUint32(register, current, rank) {
+			return
+		}
+	}
+}
+
+// Estimate returns the approximate number of distinct items added
+func (h *HyperLogLog) Estimate() uint64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for i := range h.registers {
+		rank := atomic.LoadUint32(&h.registers[i])
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	estimate := hllAlpha(len(h.registers)) * m * m / sum
+	// Small cardinalities are better served by linear counting of empty registers.
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// Merge folds other into h so h estimates the union of both sets.
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	if h.precision != other.precision {
+		return fmt.Errorf("merge precision %d into %d: %w", other.precision, h.precision, ErrPrecisionMismatch)
+	}
+	for i := range other.registers {
+		h.raise(uint64(i), atomic.LoadUint32(&other.registers[i]))
+	}
+	return nil
+}
+
+// Reset clears every register
+func (h *HyperLogLog) Reset() {
+	for i := range h.registers {
+		atomic.StoreUint32(&h.registers[i], 0)
+	}
+}
+
+// MarshalBinary encodes the sketch as a version byte, the precision and one
+// byte per register, so sketches can be shipped between processes.
+func (h *HyperLogLog) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 2+len(h.registers))
+	data[0] = hllVersion
+	data[1] = h.precision
+	for i := range h.registers {
+		data[2+i] = byte(atomic.LoadUint32(&h.registers[i]))
+	}
+	return data, nil
+}
+
+// UnmarshalBinary replaces the sketch with one produced by MarshalBinary. It
+// must not run concurrently with other calls on the same sketch.
+func (h *HyperLogLog) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 {
+		return fmt.Errorf("hyperloglog data too short: %d bytes", len(data))
+	}
+	if data[0] != hllVersion {
+		return fmt.Errorf("unsupported hyperloglog version %d", data[0])
+	}
+	precision := data[1]
+	if precision < hllMinPrecision || precision > hllMaxPrecision {
+		return fmt.Errorf("hyperloglog precision %d outside [%d, %d]", precision, hllMinPrecision, hllMaxPrecision)
+	}
+	if len(data) != 2+1<<precision {
+		return fmt.Errorf("hyperloglog data has %d registers, expected %d", len(data)-2, 1<<precision)
+	}
+
+	maxRank := 64 - int(precision) + 1
+	registers := make([]uint32, 1<<precision)
+	for i := range registers {
+		if int(data[2+i]) > maxRank {
+			return fmt.Errorf("hyperloglog register %d holds invalid rank %d", i, data[2+i])
+		}
+		registers[i] = uint32(data[2+i])
+	}
+	h.precision = precision
+	h.registers = registers
+	return nil
+}
+
+// hllHash spreads FNV-1a through a 64-bit finalizer; FNV alone leaves the
+// high bits poorly mixed for short keys, and those bits pick the register.
+func hllHash(item []byte) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write(item)
+	return mix64(hasher.Sum64())
+}
+
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func hllAlpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	default:
+		return 0.7213 / (1 + 1.079/float64(m))
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestHyperLogLogEstimate(t *testing.T) {
+	tests := []struct {
+		precision uint8
+		distinct  int
+	}{
+		{10, 100},
+		{10, 10000},
+		{14, 100000},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("p%d/%d", tt.precision, tt.distinct), func(t *testing.T) {
+			h, err := NewHyperLogLog(tt.precision)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.distinct; i++ {
+				// Every item twice: duplicates must not count.
+				h.AddString(fmt.Sprintf("item-%d", i))
+				h.AddString(fmt.Sprintf("item-%d", i))
+			}
+			// Allow four standard errors.
+			tolerance := 4 * 1.04 / math.Sqrt(float64(uint64(1)<<tt.precision))
+			got := float64(h.Estimate())
+			if math.Abs(got-float64(tt.distinct)) > tolerance*float64(tt.distinct) {
+				t.Errorf("Estimate() = %.0f, want %d within %.1f%%", got, tt.distinct, tolerance*100)
+			}
+		})
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	a, _ := NewHyperLogLog(12)
+	b, _ := NewHyperLogLog(12)
+	union, _ := NewHyperLogLog(12)
+	for i := 0; i < 3000; i++ {
+		item := fmt.Sprintf("item-%d", i)
+		if i < 2000 {
+			a.AddString(item)
+		}
+		if i >= 1000 {
+			b.AddString(item)
+		}
+		union.AddString(item)
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if a.Estimate() != union.Estimate() {
+		t.Errorf("merged estimate %d, want the union's %d", a.Estimate(), union.Estimate())
+	}
+
+	other, _ := NewHyperLogLog(10)
+	if err := a.Merge(other); !errors.Is(err, ErrPrecisionMismatch) {
+		t.Errorf("merging precision 10 into 12: err = %v, want ErrPrecisionMismatch", err)
+	}
+}
+
+func TestHyperLogLogBinary(t *testing.T) {
+	h, _ := NewHyperLogLog(8)
+	for i := 0; i < 500; i++ {
+		h.AddString(fmt.Sprintf("item-%d", i))
+	}
+	data, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded HyperLogLog
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Precision() != 8 || decoded.Estimate() != h.Estimate() {
+		t.Errorf("decoded p%d estimate %d, want p8 estimate %d", decoded.Precision(), decoded.Estimate(), h.Estimate())
+	}
+
+	tooHigh := append([]byte(nil), data...)
+	tooHigh[2] = 64
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short", data[:1]},
+		{"version", append([]byte{9}, data[1:]...)},
+		{"precision", append([]byte{hllVersion, 2}, data[2:]...)},
+		{"truncated", data[:len(data)-1]},
+		{"rank", tooHigh},
+	}
+	for _, tt := range tests {
+		if err := decoded.UnmarshalBinary(tt.data); err == nil {
+			t.Errorf("%s: UnmarshalBinary accepted bad data", tt.name)
+		}
+	}
+}
@@ -1,13 +1,25 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Counter struct {
 	value int64
+	// decreased is set to 1 the first time the value goes down, which tells
+	// metric exporters to treat the counter as a gauge.
+	decreased int32
+	// watchers stays nil until the first Watch call, so unwatched counters
+	// pay a single pointer load per update.
+	watchers atomic.Pointer[watchHub]
 }
 
 func NewCounter() *Counter {
@@ -16,12 +28,17 @@ func NewCounter() *Counter {
 
 // Increment atomically increases the counter by 1
 func (c *Counter) Increment() int64 {
-	return atomic.AddInt64(&c.value, 1)
+	v := atomic.AddInt64(&c.value, 1)
+	c.notify()
+	return v
 }
 
 // Decrement atomically decreases the counter by 1
 func (c *Counter) Decrement() int64 {
-	return atomic.AddInt64(&c.value, -1)
+	c.markDecreased()
+	v := atomic.AddInt64(&c.value, -1)
+	c.notify()
+	return v
 }
 
 // Get returns the current value of the counter
@@ -31,15 +48,72 @@ func (c *Counter) Get() int64 {
 
 // AddValue atomically adds a value to the counter
 func (c *Counter) AddValue(val int64) int64 {
-	return atomic.AddInt64(&c.value, val)
+	if val < 0 {
+		c.markDecreased()
+	}
+	v := atomic.AddInt64(&c.value, val)
+	c.notify()
+	return v
 }
 
 // Reset sets the counter back to 0
 func (c *Counter) Reset() {
+	c.markDecreased()
 	atomic.StoreInt64(&c.value, 0)
+	c.notify()
+}
+
+// CompareAndSwap sets the counter to new only if it currently holds old
+func (c *Counter) CompareAndSwap(old, new int64) bool {
+	if !atomic.CompareAndSwapInt64(&c.value, old, new) {
+		return false
+	}
+	if new < old {
+		c.markDecreased()
+	}
+	c.notify()
+	return true
+}
+
+// Swap atomically stores val and returns the previous value
+func (c *Counter) Swap(val int64) int64 {
+	old := atomic.SwapInt64(&c.value, val)
+	if val < old {
+		c.markDecreased()
+	}
+	c.notify()
+	return old
+}
+
+// Decreased reports whether the counter has ever gone down
+func (c *Counter) Decreased() bool {
+	return atomic.LoadInt32(&c.decreased) == 1
+}
+
+// markDecreased only writes the first time so the hot path stays a single load
+func (c *Counter) markDecreased() {
+	if atomic.LoadInt32(&c.decreased) == 0 {
+		atomic.StoreInt32(&c.decreased, 1)
+	}
 }
 
 func main() {
+	if len(os.Args) > 2 && os.Args[1] == "raft" {
+		if err := runRaftProcess(os.Args[2], os.Args[3:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 2 && os.Args[1] == "resp" {
+		fmt.Printf("Serving counters over RESP on %s\n", os.Args[2])
+		if err := NewRESPServer().ListenAndServe(os.Args[2]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	counter := NewCounter()
 	var wg sync.WaitGroup
 
@@ -85,4 +159,318 @@ func main() {
 	// Test reset
 	counter.Reset()
 	fmt.Printf("After reset: %d\n", counter.Get())
+
+	runGossipDemo()
+	runRaftDemo()
+	runMetricsDemo()
+	runBoundedDemo()
+	runHyperLogLogDemo()
+	runCountMinDemo()
+	runQuotaDemo()
+	runWatchDemo()
+	runRESPDemo()
+}
+
+// runGossipDemo starts three gossiping nodes on localhost, updates each one
+// and waits for them to agree on the total.
+func runGossipDemo() {
+	addrs := []string{"127.0.0.1:7101", "127.0.0.1:7102", "127.0.0.1:7103"}
+	nodes := make([]*GossipNode, len(addrs))
+	for i, addr := range addrs {
+		var peers []string
+		for _, other := range addrs {
+			if other != addr {
+				peers = append(peers, other)
+			}
+		}
+		nodes[i] = NewGossipNode(fmt.Sprintf("node-%d", i+1), addr, peers, 50*time.Millisecond)
+		if err := nodes[i].Start(); err != nil {
+			fmt.Printf("Gossip node %s failed to start: %s\n", addr, err)
+			return
+		}
+		defer nodes[i].Stop()
+	}
+
+	nodes[0].AddValue(10)
+	nodes[1].Increment()
+	nodes[2].Decrement()
+
+	time.Sleep(500 * time.Millisecond)
+	for _, node := range nodes {
+		fmt.Printf("Gossip %s sees %d (lag %s)\n", node.id, node.Get(), node.ConvergenceLag())
+	}
+}
+
+// runRaftDemo runs a three node in-process Raft cluster, crashes the leader
+// mid-way and shows the counter carrying on with the survivors.
+func runRaftDemo() {
+	cluster, err := NewRaftCluster(3, RaftConfig{SnapshotThreshold: 50})
+	if err != nil {
+		fmt.Printf("Raft cluster failed to start: %s\n", err)
+		return
+	}
+	defer cluster.Stop()
+
+	for i := 0; i < 100; i++ {
+		if _, err := cluster.Increment(); err != nil {
+			fmt.Printf("Raft increment failed: %s\n", err)
+			return
+		}
+	}
+
+	leader := cluster.Leader()
+	cluster.Network.Disconnect(leader.ID())
+	fmt.Printf("Raft disconnected leader %s\n", leader.ID())
+	time.Sleep(500 * time.Millisecond)
+
+	if _, err := cluster.AddValue(50); err != nil {
+		fmt.Printf("Raft add failed: %s\n", err)
+		return
+	}
+	cluster.Network.Reconnect(leader.ID())
+
+	value, err := cluster.Get()
+	if err != nil {
+		fmt.Printf("Raft get failed: %s\n", err)
+		return
+	}
+	fmt.Printf("Raft counter value: %d (leader %s)\n", value, cluster.Leader().ID())
+}
+
+// runMetricsDemo registers a few counters and prints what /metrics would serve.
+func runMetricsDemo() {
+	registry := NewRegistry()
+	requests := NewCounter()
+	failures := NewCounter()
+	inFlight := NewCounter()
+
+	registry.Register("http_requests_total", "Requests handled.", map[string]string{"code": "200"}, requests)
+	registry.Register("http_requests_total", "Requests handled.", map[string]string{"code": "500"}, failures)
+	registry.Register("http_in_flight", "Requests being served.", nil, inFlight)
+
+	requests.AddValue(42)
+	failures.Increment()
+	inFlight.AddValue(3)
+	inFlight.Decrement()
+
+	registry.WriteText(os.Stdout)
+}
+
+// runBoundedDemo uses a bounded counter as a 10 seat inventory shared by 50
+// buyers; exactly 10 purchases succeed without any lock.
+func runBoundedDemo() {
+	seats, err := NewBoundedCounter(0, 10, 10)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	var sold int64
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := seats.TryDecrement(); ok {
+				atomic.AddInt64(&sold, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	fmt.Printf("Seats sold: %d, remaining: %d\n", sold, seats.Get())
+}
+
+// runHyperLogLogDemo counts unique users seen by two processes, ships one
+// sketch over the wire and merges them.
+func runHyperLogLogDemo() {
+	morning, _ := NewHyperLogLog(14)
+	evening, _ := NewHyperLogLog(14)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := worker; i < 60000; i += 4 {
+				morning.AddString(fmt.Sprintf("user-%d", i))
+				evening.AddString(fmt.Sprintf("user-%d", i+40000))
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	data, _ := evening.MarshalBinary()
+	received, _ := NewHyperLogLog(4)
+	if err := received.UnmarshalBinary(data); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := morning.Merge(received); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Unique users today: ~%d (actual 100000, sketch %d bytes)\n", morning.Estimate(), len(data))
+}
+
+// runCountMinDemo counts requests per client IP on two servers and merges
+// the sketches to find the busiest clients overall.
+func runCountMinDemo() {
+	east, _ := NewCountMinSketch(0.001, 0.01, 3)
+	west, _ := NewCountMinSketch(0.001, 0.01, 3)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 5000; i++ {
+				east.Increment(fmt.Sprintf("10.0.%d.%d", worker, i%250))
+				west.Increment(fmt.Sprintf("10.1.%d.%d", worker, i%250))
+			}
+			east.Add("10.9.9.9", 800)
+			west.Add("10.9.9.9", 700)
+			west.Add("10.8.8.8", 1000)
+		}(worker)
+	}
+	wg.Wait()
+
+	if err := east.Merge(west); err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, hitter := range east.TopK() {
+		fmt.Printf("Heavy hitter %s: ~%d requests\n", hitter.Key, hitter.Count)
+	}
+}
+
+// runQuotaDemo shares a budget of 1000 requests between three nodes, one of
+// which crashes while holding a lease.
+func runQuotaDemo() {
+	coordinator := NewQuotaCoordinator(1000, 200*time.Millisecond)
+	var nodes []*QuotaNode
+	for _, id := range []string{"api-1", "api-2", "api-3"} {
+		node, err := NewQuotaNode(id, coordinator, 50, 20*time.Millisecond)
+		if err != nil {
+			fmt.Printf("Quota node %s failed: %s\n", id, err)
+			return
+		}
+		nodes = append(nodes, node)
+	}
+	nodes, crashed := nodes[:2], nodes[2]
+	crashed.TryConsume(10) // stands in for a node that died holding a 50 token lease
+
+	var wg sync.WaitGroup
+	var allowed int64
+	for _, node := range nodes {
+		for worker := 0; worker < 4; worker++ {
+			wg.Add(1)
+			go func(node *QuotaNode) {
+				defer wg.Done()
+				for i := 0; i < 200; i++ {
+					if node.TryConsume(1) {
+						atomic.AddInt64(&allowed, 1)
+					}
+				}
+			}(node)
+		}
+	}
+	wg.Wait()
+	for _, node := range nodes {
+		node.Close()
+	}
+
+	stats := coordinator.Stats()
+	fmt.Printf("Quota allowed %d requests (+%d on the crashed node), used %d, outstanding %d, limit %d\n",
+		allowed, crashed.Consumed(), stats.Used, stats.Outstanding, stats.Limit)
+}
+
+// runWatchDemo alerts when stock drops below a reorder level while a slow
+// dashboard follows the latest value.
+func runWatchDemo() {
+	stock := NewCounter()
+	stock.AddValue(100)
+
+	reorder, stopReorder := stock.WatchThreshold(20, WatchDown)
+	defer stopReorder()
+	dashboard, stopDashboard := stock.Watch()
+	defer stopDashboard()
+
+	for i := 0; i < 90; i++ {
+		stock.Decrement()
+	}
+
+	select {
+	case ev := <-reorder:
+		fmt.Printf("Reorder alert: stock fell from %d to %d\n", ev.Previous, ev.Value)
+	case <-time.After(time.Second):
+		fmt.Println("Reorder alert never fired")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	ev := <-dashboard
+	fmt.Printf("Dashboard shows stock %d\n", ev.Value)
+}
+
+// runRESPDemo talks to the counter server the way redis-cli would.
+func runRESPDemo() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	server := NewRESPServer()
+	go server.Serve(listener)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	commands := []string{
+		"*2\r\n$4\r\nINCR\r\n$9\r\npage:home\r\n",
+		"INCRBY page:home 41\r\n",
+		"SET page:about 7\r\n",
+		"KEYS page:*\r\n",
+		"GET page:home\r\n",
+	}
+	for _, command := range commands {
+		conn.Write([]byte(command))
+		reply, err := readRESPReply(reader)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("RESP %s -> %s\n", strings.TrimSpace(strings.ReplaceAll(command, "\r\n", " ")), reply)
+	}
+}
+
+// readRESPReply reads one reply and flattens it into a printable string.
+func readRESPReply(r *bufio.Reader) (string, error) {
+	line, err := readRESPLine(r)
+	if err != nil || len(line) == 0 {
+		return line, err
+	}
+	switch line[0] {
+	case '$':
+		if line == "$-1" {
+			return "(nil)", nil
+		}
+		return readRESPLine(r)
+	case '*':
+		count, _ := strconv.Atoi(line[1:])
+		items := make([]string, count)
+		for i := range items {
+			if items[i], err = readRESPReply(r); err != nil {
+				return "", err
+			}
+		}
+		return "[" + strings.Join(items, " ") + "]", nil
+	default:
+		return line[1:], nil
+	}
 }
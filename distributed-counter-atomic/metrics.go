@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRe  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+type metricSeries struct {
+	labels  map[string]string
+	counter *Counter
+	gauge   bool // pinned as a gauge at registration
+}
+
+type metricFamily struct {
+	help   string
+	series []*metricSeries
+}
+
+// Registry collects named counters and renders them in the Prometheus text
+// exposition format.
+type Registry struct {
+	mu       sync.RWMutex
+	families map[string]*metricFamily
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*metricFamily)}
+}
+
+// Register adds c under name with the given labels. It is exposed as a
+// Prometheus counter until it is decremented or reset, after which the whole
+// family is typed as a gauge.
+func (r *Registry) Register(name, help string, labels map[string]string, c *Counter) error {
+	return r.register(name, help, labels, c, false)
+}
+
+// RegisterGauge adds c as a gauge from the start, for values that are
+// expected to go up and down.
+func (r *Registry) RegisterGauge(name, help string, labels map[string]string, c *Counter) error {
+	return r.register(name, help, labels, c, true)
+}
+
+func (r *Registry) register(name, help string, labels map[string]string, c *Counter, gauge bool) error {
+	if !metricNameRe.MatchString(name) {
+		return fmt.Errorf("invalid metric name %q", name)
+	}
+	for label := range labels {
+		if !labelNameRe.MatchString(label) || strings.HasPrefix(label, "__") {
+			return fmt.Errorf("invalid label name %q for metric %s", label, name)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	family, ok := r.families[name]
+	if !ok {
+		family = &metricFamily{help: help}
+		r.families[name] = family
+	}
+	key := labelString(labels)
+	for _, s := range family.series {
+		if labelString(s.labels) == key {
+			return fmt.Errorf("metric %s%s is already registered", name, key)
+		}
+	}
+
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	family.series = append(family.series, &metricSeries{labels: copied, counter: c, gauge: gauge})
+	return nil
+}
+
+// Unregister removes the series registered under name with exactly these labels.
+func (r *Registry) Unregister(name string, labels map[string]string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	family, ok := r.families[name]
+	if !ok {
+		return false
+	}
+	key := labelString(labels)
+	for i, s := range family.series {
+		if labelString(s.labels) == key {
+			family.series = append(family.series[:i], family.series[i+1:]...)
+			if len(family.series) == 0 {
+				delete(r.families, name)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText renders every registered family, sorted by name and labels so
+// scrapes are stable.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		family := r.families[name]
+		series := make([]*metricSeries, len(family.series))
+		copy(series, family.series)
+		sort.Slice(series, func(i, j int) bool {
+			return labelString(series[i].labels) < labelString(series[j].labels)
+		})
+
+		metricType := "counter"
+		for _, s := range series {
+			if s.gauge || s.counter.Decreased() {
+				metricType = "gauge"
+				break
+			}
+		}
+
+		if family.help != "" {
+			fmt.Fprintf(&buf, "# HELP %s %s\n", name, escapeHelp(family.help))
+		}
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, metricType)
+		for _, s := range series {
+			fmt.Fprintf(&buf, "%s%s %d\n", name, labelString(s.labels), s.counter.Get())
+		}
+	}
+	r.mu.RUnlock()
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ServeHTTP serves the exposition so the registry can be mounted at /metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteText(w)
+}
+
+// ServeMetrics starts an HTTP server exposing the registry at /metrics. It
+// listens before returning, so a port already in use is reported here.
+func ServeMetrics(addr string, r *Registry) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	server := &http.Server{Addr: listener.Addr().String(), Handler: mux}
+	go server.Serve(listener)
+	return server, nil
+}
+
+// labelString formats labels as {a="1",b="2"} with keys sorted, or "" when empty.
+func labelString(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf(`%s="%s"`, k, escapeLabelValue(labels[k]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func escapeHelp(v string) string {
+	return helpEscaper.Replace(v)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrQuotaExhausted = errors.New("quota: global budget exhausted")
+	ErrUnknownLease   = errors.New("quota: lease unknown or already expired")
+)
+
+// Lease is a chunk of the global budget handed to one node. The node may
+// spend up to Tokens within TTL of asking for it.
+type Lease struct {
+	ID     uint64
+	Tokens int64
+	TTL    time.Duration
+}
+
+// LeaseGranter is what a QuotaNode needs from the coordinator, so the
+// coordinator can sit behind a network call instead of in the same process.
+type LeaseGranter interface {
+	Acquire(nodeID string, want int64) (Lease, error)
+	Return(leaseID uint64, unused int64) error
+	LeaseTTL() time.Duration
+}
+
+type outstandingLease struct {
+	nodeID  string
+	tokens  int64
+	expires time.Time
+}
+
+type QuotaStats struct {
+	Limit       int64
+	Used        int64 // spent or lost with an expired lease
+	Outstanding int64 // leased and not yet returned
+	Remaining   int64
+}
+
+// QuotaCoordinator owns the global budget. Leased tokens count against the
+// limit until they are returned, and a lease that expires without being
+// returned is treated as fully spent, so a crashed node can only make the
+// quota stricter, never let it be exceeded.
+type QuotaCoordinator struct {
+	mu          sync.Mutex
+	limit       int64
+	used        int64
+	outstanding int64
+	ttl         time.Duration
+	nextID      uint64
+	leases      map[uint64]*outstandingLease
+}
+
+func NewQuotaCoordinator(limit int64, ttl time.Duration) *QuotaCoordinator {
+	return &QuotaCoordinator{
+		limit:  limit,
+		ttl:    ttl,
+		leases: make(map[uint64]*outstandingLease),
+	}
+}
+
+// Acquire leases up to want tokens to nodeID, fewer if the budget is low.
+func (q *QuotaCoordinator) Acquire(nodeID string, want int64) (Lease, error) {
+	if want <= 0 {
+		return Lease{}, fmt.Errorf("quota: lease size %d must be positive", want)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.expireLocked(time.Now())
+
+	available := q.limit - q.used - q.outstanding
+	if available <= 0 {
+		return Lease{}, ErrQuotaExhausted
+	}
+	tokens := min(want, available)
+
+	q.nextID++
+	q.leases[q.nextID] = &outstandingLease{
+		nodeID:  nodeID,
+		tokens:  tokens,
+		expires: time.Now().Add(q.ttl),
+	}
+	q.outstanding += tokens
+	return Lease{ID: q.nextID, Tokens: tokens, TTL: q.ttl}, nil
+}
+
+// LeaseTTL returns how long every lease is valid for.
+func (q *QuotaCoordinator) LeaseTTL() time.Duration {
+	return q.ttl
+}
+
+// Return hands back the unused part of a lease before it expires.
+func (q *QuotaCoordinator) Return(leaseID uint64, unused int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.expireLocked(time.Now())
+
+	lease, ok := q.leases[leaseID]
+	if !ok {
+		return ErrUnknownLease
+	}
+	if unused < 0 || unused > lease.tokens {
+		return fmt.Errorf("quota: lease %d returned %d of %d tokens", leaseID, unused, lease.tokens)
+	}
+
+	delete(q.leases, leaseID)
+	q.outstanding -= lease.tokens
+	q.used += lease.tokens - unused
+	return nil
+}
+
+// Stats returns a snapshot of the budget
+func (q *QuotaCoordinator) Stats() QuotaStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.expireLocked(time.Now())
+
+	return QuotaStats{
+		Limit:       q.limit,
+		Used:        q.used,
+		Outstanding: q.outstanding,
+		Remaining:   q.limit - q.used - q.outstanding,
+	}
+}
+
+// expireLocked writes off leases whose holders never came back.
+func (q *QuotaCoordinator) expireLocked(now time.Time) {
+	for id, lease := range q.leases {
+		if now.After(lease.expires) {
+			delete(q.leases, id)
+			q.outstanding -= lease.tokens
+			q.used += lease.tokens
+		}
+	}
+}
+
+// localLease is the node's copy of a lease. Its tokens live in a bounded
+// counter so consuming them is a lock-free compare-and-swap.
+type localLease struct {
+	id      uint64
+	tokens  *BoundedCounter
+	expires time.Time
+}
+
+// QuotaNode spends a shared budget locally, going back to the coordinator
+// only when its current lease runs out or is about to expire.
+type QuotaNode struct {
+	id        string
+	granter   LeaseGranter
+	chunk     int64
+	margin    time.Duration // stop using a lease this long before it expires
+	current   atomic.Pointer[localLease]
+	consumed  *Counter
+	acquireMu sync.Mutex
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewQuotaNode returns a node that leases chunk tokens at a time. Leases are
+// given up margin before the coordinator would expire them, which covers the
+// time the Acquire call spent in flight and clock drift between the two, so
+// margin must be shorter than the lease TTL.
+func NewQuotaNode(id string, granter LeaseGranter, chunk int64, margin time.Duration) (*QuotaNode, error) {
+	if chunk <= 0 {
+		return nil, fmt.Errorf("quota: lease size %d must be positive", chunk)
+	}
+	if ttl := granter.LeaseTTL(); margin < 0 || margin >= ttl {
+		return nil, fmt.Errorf("quota: margin %s must be between 0 and the lease TTL %s", margin, ttl)
+	}
+	node := &QuotaNode{
+		id:       id,
+		granter:  granter,
+		chunk:    chunk,
+		margin:   margin,
+		consumed: NewCounter(),
+		stop:     make(chan struct{}),
+	}
+	node.wg.Add(1)
+	go node.releaseLoop()
+	return node, nil
+}
+
+// TryConsume spends n tokens, leasing more if needed. It returns false once
+// the global budget cannot cover the request, or if tokens is not positive.
+func (n *QuotaNode) TryConsume(tokens int64) bool {
+	if tokens <= 0 {
+		return false
+	}
+	if n.consumeLocal(tokens) {
+		return true
+	}
+
+	n.acquireMu.Lock()
+	defer n.acquireMu.Unlock()
+	// Another goroutine may have refreshed the lease while we waited.
+	if n.consumeLocal(tokens) {
+		return true
+	}
+
+	n.release()
+	// Measure expiry from before the request so the local deadline is never
+	// later than the coordinator's.
+	requested := time.Now()
+	lease, err := n.granter.Acquire(n.id, max(n.chunk, tokens))
+	if err != nil {
+		return false
+	}
+	tokensCounter, _ := NewBoundedCounter(0, lease.Tokens, lease.Tokens)
+	n.current.Store(&localLease{
+		id:      lease.ID,
+		tokens:  tokensCounter,
+		expires: requested.Add(lease.TTL - n.margin),
+	})
+	return n.consumeLocal(tokens)
+}
+
+// Consumed returns how many tokens this node has spent
+func (n *QuotaNode) Consumed() int64 {
+	return n.consumed.Get()
+}
+
+// Close returns the unused part of the current lease and stops the node.
+func (n *QuotaNode) Close() {
+	close(n.stop)
+	n.wg.Wait()
+	n.acquireMu.Lock()
+	n.release()
+	n.acquireMu.Unlock()
+}
+
+func (n *QuotaNode) consumeLocal(tokens int64) bool {
+	lease := n.current.Load()
+	if lease == nil || time.Now().After(lease.expires) {
+		return false
+	}
+	if _, ok := lease.tokens.TryAdd(-tokens); !ok {
+		return false
+	}
+	n.consumed.AddValue(tokens)
+	return true
+}
+
+// release drains the current lease and returns what is left. Swapping the
+// tokens to zero first guarantees nothing is spent after the return.
+// Callers must hold acquireMu.
+func (n *QuotaNode) release() {
+	lease := n.current.Swap(nil)
+	if lease == nil {
+		return
+	}
+	unused, _ := lease.tokens.Swap(0)
+	// If the coordinator already wrote the lease off this fails harmlessly.
+	n.granter.Return(lease.id, unused)
+}
+
+// releaseLoop hands back leases once the node stops using them, margin
+// before the coordinator would expire them, so their unused tokens go back
+// to the pool instead of being written off.
+func (n *QuotaNode) releaseLoop() {
+	defer n.wg.Done()
+	interval := n.margin / 2
+	if interval <= 0 {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+			lease := n.current.Load()
+			// The local deadline already has the margin taken off.
+			if lease == nil || time.Now().Before(lease.expires) {
+				continue
+			}
+			n.acquireMu.Lock()
+			if n.current.Load() == lease {
+				n.release()
+			}
+			n.acquireMu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewQuotaNodeChecksArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunk  int64
+		margin time.Duration
+		ok     bool
+	}{
+		{"valid", 10, 20 * time.Millisecond, true},
+		{"no margin", 10, 0, true},
+		{"margin near ttl", 10, 90 * time.Millisecond, true},
+		{"margin equals ttl", 10, 100 * time.Millisecond, false},
+		{"negative margin", 10, -time.Millisecond, false},
+		{"zero chunk", 0, 20 * time.Millisecond, false},
+		{"negative chunk", -5, 20 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := NewQuotaNode("a", NewQuotaCoordinator(100, 100*time.Millisecond), tt.chunk, tt.margin)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v, want ok %v", err, tt.ok)
+			}
+			if node != nil {
+				node.Close()
+			}
+		})
+	}
+}
+
+func TestQuotaNodeRejectsNonPositiveTokens(t *testing.T) {
+	coordinator := NewQuotaCoordinator(100, time.Second)
+	node, err := NewQuotaNode("a", coordinator, 10, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+
+	for _, tokens := range []int64{0, -5} {
+		if node.TryConsume(tokens) {
+			t.Errorf("TryConsume(%d) succeeded", tokens)
+		}
+	}
+	if !node.TryConsume(5) || node.Consumed() != 5 {
+		t.Fatalf("consumed %d, want 5", node.Consumed())
+	}
+}
+
+func TestQuotaNeverExceedsLimit(t *testing.T) {
+	coordinator := NewQuotaCoordinator(1000, time.Second)
+	var nodes []*QuotaNode
+	for _, id := range []string{"a", "b", "c"} {
+		node, err := NewQuotaNode(id, coordinator, 30, 100*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for node.TryConsume(1) {
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	total := int64(0)
+	for _, node := range nodes {
+		node.Close()
+		total += node.Consumed()
+	}
+	if total != 1000 {
+		t.Errorf("nodes consumed %d, want exactly the limit of 1000", total)
+	}
+	if stats := coordinator.Stats(); stats.Used != 1000 || stats.Outstanding != 0 {
+		t.Errorf("stats = %+v, want all 1000 used and none outstanding", stats)
+	}
+}
+
+func TestQuotaLeaseUsableUntilMargin(t *testing.T) {
+	coordinator := NewQuotaCoordinator(100, 200*time.Millisecond)
+	node, err := NewQuotaNode("a", coordinator, 10, 80*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+
+	if !node.TryConsume(1) {
+		t.Fatal("first consume failed")
+	}
+	// Past TTL - 2*margin but well before TTL - margin, the lease is still
+	// in use: nothing has been handed back yet.
+	time.Sleep(70 * time.Millisecond)
+	if stats := coordinator.Stats(); stats.Outstanding != 10 {
+		t.Fatalf("lease released early: %+v", stats)
+	}
+	if !node.TryConsume(1) {
+		t.Fatal("consume on a live lease failed")
+	}
+
+	// Once the node stops using it, the unused tokens go back to the pool
+	// before the coordinator would write them off.
+	time.Sleep(130 * time.Millisecond)
+	if stats := coordinator.Stats(); stats.Outstanding != 0 || stats.Used != 2 {
+		t.Fatalf("stats = %+v, want 2 used and the rest returned", stats)
+	}
+}
@@ -0,0 +1,869 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrNotLeader      = errors.New("raft: node is not the leader")
+	ErrLeadershipLost = errors.New("raft: leadership lost before the entry committed")
+	ErrRaftTimeout    = errors.New("raft: timed out waiting for commit")
+	ErrUnreachable    = errors.New("raft: peer unreachable")
+	ErrRaftStopped    = errors.New("raft: node stopped")
+)
+
+type raftRole int
+
+const (
+	raftFollower raftRole = iota
+	raftCandidate
+	raftLeader
+)
+
+func (r raftRole) String() string {
+	switch r {
+	case raftLeader:
+		return "leader"
+	case raftCandidate:
+		return "candidate"
+	default:
+		return "follower"
+	}
+}
+
+type raftOp int
+
+const (
+	raftOpNoop raftOp = iota // appended by every new leader to commit earlier terms
+	raftOpAdd
+	raftOpRead // goes through the log so reads are linearizable
+)
+
+type RaftEntry struct {
+	Term  int64
+	Op    raftOp
+	Delta int64
+}
+
+type RequestVoteArgs struct {
+	Term         int64
+	CandidateID  string
+	LastLogIndex int64
+	LastLogTerm  int64
+}
+
+type RequestVoteReply struct {
+	Term    int64
+	Granted bool
+}
+
+type AppendEntriesArgs struct {
+	Term         int64
+	LeaderID     string
+	PrevLogIndex int64
+	PrevLogTerm  int64
+	Entries      []RaftEntry
+	LeaderCommit int64
+}
+
+type AppendEntriesReply struct {
+	Term    int64
+	Success bool
+	// ConflictIndex lets the leader skip back a whole term at a time instead
+	// of probing one entry per round trip.
+	ConflictIndex int64
+}
+
+type InstallSnapshotArgs struct {
+	Term              int64
+	LeaderID          string
+	LastIncludedIndex int64
+	LastIncludedTerm  int64
+	Value             int64
+}
+
+type InstallSnapshotReply struct {
+	Term int64
+}
+
+// RaftTransport delivers RPCs to peers by id. MemoryNetwork provides an
+// in-process implementation and RPCTransport talks to other processes.
+type RaftTransport interface {
+	RequestVote(peer string, args *RequestVoteArgs) (*RequestVoteReply, error)
+	AppendEntries(peer string, args *AppendEntriesArgs) (*AppendEntriesReply, error)
+	InstallSnapshot(peer string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error)
+}
+
+type RaftConfig struct {
+	ID                string
+	Peers             []string      // ids of the other cluster members
+	ElectionTimeout   time.Duration // randomized between 1x and 2x
+	HeartbeatInterval time.Duration
+	SnapshotThreshold int           // compact the log once it holds this many applied entries
+	ProposeTimeout    time.Duration // how long Increment/AddValue/Get wait for commit
+	// DataDir is where the term, vote and log are kept across restarts.
+	// Empty keeps them in memory only, which is fine for an in-process
+	// cluster but unsafe for a node that can restart on its own.
+	DataDir string
+}
+
+type raftResult struct {
+	term  int64
+	value int64
+}
+
+// RaftNode replicates a Counter through a Raft log. Every mutation and read
+// is committed by a majority before it is answered, so the counter is
+// linearizable as long as a majority of nodes can talk to each other.
+type RaftNode struct {
+	mu        sync.Mutex
+	cfg       RaftConfig
+	transport RaftTransport
+
+	role        raftRole
+	currentTerm int64
+	votedFor    string
+	leaderID    string
+
+	// log[0] is a sentinel standing in for the last snapshotted entry, so
+	// index i lives at log[i-snapshotIndex].
+	log           []RaftEntry
+	snapshotIndex int64
+	snapshotValue int64
+
+	commitIndex int64
+	lastApplied int64
+	nextIndex   map[string]int64
+	matchIndex  map[string]int64
+
+	counter          *Counter
+	dirty            bool // persistent state changed since it was last written
+	waiters          map[int64]chan raftResult
+	electionDeadline time.Time
+	lastHeartbeat    time.Time
+
+	stop    chan struct{}
+	stopped atomic.Bool
+	wg      sync.WaitGroup
+}
+
+// NewRaftNode returns a node that is not yet running; call Start once the
+// transport can reach it.
+func NewRaftNode(cfg RaftConfig, transport RaftTransport) *RaftNode {
+	if cfg.ElectionTimeout == 0 {
+		cfg.ElectionTimeout = 150 * time.Millisecond
+	}
+	if cfg.HeartbeatInterval == 0 {
+		cfg.HeartbeatInterval = 30 * time.Millisecond
+	}
+	if cfg.SnapshotThreshold == 0 {
+		cfg.SnapshotThreshold = 1000
+	}
+	if cfg.ProposeTimeout == 0 {
+		cfg.ProposeTimeout = 2 * time.Second
+	}
+
+	return &RaftNode{
+		cfg:        cfg,
+		transport:  transport,
+		log:        []RaftEntry{{Term: 0, Op: raftOpNoop}},
+		nextIndex:  make(map[string]int64),
+		matchIndex: make(map[string]int64),
+		counter:    NewCounter(),
+		waiters:    make(map[int64]chan raftResult),
+		stop:       make(chan struct{}),
+	}
+}
+
+// Start restores any state saved in cfg.DataDir and launches the election
+// and heartbeat loop.
+func (r *RaftNode) Start() error {
+	r.mu.Lock()
+	if err := r.loadStateLocked(); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	r.resetElectionDeadlineLocked()
+	r.unlock()
+
+	r.wg.Add(1)
+	go r.run()
+	return nil
+}
+
+// unlock persists any state changed under r.mu before releasing it, so no
+// reply or message built from that state leaves before it is on disk.
+func (r *RaftNode) unlock() {
+	r.persistLocked()
+	r.mu.Unlock()
+}
+
+// Stop halts the node. It stops answering RPCs, which to the rest of the
+// cluster looks like a crash.
+func (r *RaftNode) Stop() {
+	if r.stopped.Swap(true) {
+		return
+	}
+	close(r.stop)
+	r.wg.Wait()
+	// Wait out any handler still holding the lock; after this nothing is
+	// persisted, so a replacement node owns the state file.
+	r.mu.Lock()
+	r.mu.Unlock()
+}
+
+func (r *RaftNode) ID() string {
+	return r.cfg.ID
+}
+
+// State returns the node's current term, role and the leader it knows of.
+func (r *RaftNode) State() (term int64, role string, leader string) {
+	r.mu.Lock()
+	defer r.unlock()
+	return r.currentTerm, r.role.String(), r.leaderID
+}
+
+// Increment atomically increases the replicated counter by 1
+func (r *RaftNode) Increment() (int64, error) {
+	return r.propose(RaftEntry{Op: raftOpAdd, Delta: 1})
+}
+
+// Decrement atomically decreases the replicated counter by 1
+func (r *RaftNode) Decrement() (int64, error) {
+	return r.propose(RaftEntry{Op: raftOpAdd, Delta: -1})
+}
+
+// AddValue atomically adds a value to the replicated counter
+func (r *RaftNode) AddValue(val int64) (int64, error) {
+	return r.propose(RaftEntry{Op: raftOpAdd, Delta: val})
+}
+
+// Get returns the committed value of the counter as of a point after the call started
+func (r *RaftNode) Get() (int64, error) {
+	return r.propose(RaftEntry{Op: raftOpRead})
+}
+
+// propose appends entry to the leader's log and waits for it to be applied.
+func (r *RaftNode) propose(entry RaftEntry) (int64, error) {
+	r.mu.Lock()
+	if r.role != raftLeader {
+		r.unlock()
+		return 0, ErrNotLeader
+	}
+	entry.Term = r.currentTerm
+	r.log = append(r.log, entry)
+	r.dirty = true
+	index := r.lastIndexLocked()
+	ch := make(chan raftResult, 1)
+	r.waiters[index] = ch
+	r.advanceCommitLocked()
+	r.unlock()
+
+	r.broadcastAppend()
+
+	select {
+	case res := <-ch:
+		if res.term != entry.Term {
+			return 0, ErrLeadershipLost
+		}
+		return res.value, nil
+	case <-time.After(r.cfg.ProposeTimeout):
+		r.mu.Lock()
+		delete(r.waiters, index)
+		r.unlock()
+		return 0, ErrRaftTimeout
+	case <-r.stop:
+		return 0, ErrRaftStopped
+	}
+}
+
+func (r *RaftNode) run() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.cfg.HeartbeatInterval / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+
+		r.mu.Lock()
+		now := time.Now()
+		switch {
+		case r.role == raftLeader && now.Sub(r.lastHeartbeat) >= r.cfg.HeartbeatInterval:
+			r.lastHeartbeat = now
+			r.unlock()
+			r.broadcastAppend()
+			continue
+		case r.role != raftLeader && now.After(r.electionDeadline):
+			r.startElectionLocked()
+		}
+		r.unlock()
+	}
+}
+
+func (r *RaftNode) resetElectionDeadlineLocked() {
+	timeout := r.cfg.ElectionTimeout + time.Duration(rand.Int63n(int64(r.cfg.ElectionTimeout)))
+	r.electionDeadline = time.Now().Add(timeout)
+}
+
+func (r *RaftNode) lastIndexLocked() int64 {
+	return r.snapshotIndex + int64(len(r.log)) - 1
+}
+
+func (r *RaftNode) termAtLocked(index int64) int64 {
+	return r.log[index-r.snapshotIndex].Term
+}
+
+func (r *RaftNode) majority() int {
+	return (len(r.cfg.Peers)+1)/2 + 1
+}
+
+func (r *RaftNode) becomeFollowerLocked(term int64) {
+	r.role = raftFollower
+	if term > r.currentTerm {
+		r.currentTerm = term
+		r.votedFor = ""
+		r.dirty = true
+	}
+}
+
+func (r *RaftNode) startElectionLocked() {
+	r.role = raftCandidate
+	r.currentTerm++
+	r.votedFor = r.cfg.ID
+	r.leaderID = ""
+	r.dirty = true
+	r.resetElectionDeadlineLocked()
+
+	args := &RequestVoteArgs{
+		Term:         r.currentTerm,
+		CandidateID:  r.cfg.ID,
+		LastLogIndex: r.lastIndexLocked(),
+		LastLogTerm:  r.termAtLocked(r.lastIndexLocked()),
+	}
+	votes := 1
+	if votes >= r.majority() {
+		r.becomeLeaderLocked()
+		return
+	}
+
+	// The requests leave while r.mu is still held, so the new term and vote
+	// must reach disk first.
+	r.persistLocked()
+	for _, peer := range r.cfg.Peers {
+		go func(peer string) {
+			reply, err := r.transport.RequestVote(peer, args)
+			if err != nil {
+				return
+			}
+
+			r.mu.Lock()
+			defer r.unlock()
+			if reply.Term > r.currentTerm {
+				r.becomeFollowerLocked(reply.Term)
+				return
+			}
+			if r.role != raftCandidate || r.currentTerm != args.Term || !reply.Granted {
+				return
+			}
+			votes++
+			if votes >= r.majority() {
+				r.becomeLeaderLocked()
+			}
+		}(peer)
+	}
+}
+
+func (r *RaftNode) becomeLeaderLocked() {
+	r.role = raftLeader
+	r.leaderID = r.cfg.ID
+	for _, peer := range r.cfg.Peers {
+		r.nextIndex[peer] = r.lastIndexLocked() + 1
+		r.matchIndex[peer] = 0
+	}
+	// A leader may only count replicas for entries of its own term, so it
+	// commits a no-op right away to settle whatever earlier terms left behind.
+	r.log = append(r.log, RaftEntry{Term: r.currentTerm, Op: raftOpNoop})
+	r.dirty = true
+	r.advanceCommitLocked()
+	r.lastHeartbeat = time.Time{}
+}
+
+func (r *RaftNode) broadcastAppend() {
+	for _, peer := range r.cfg.Peers {
+		go r.replicateTo(peer)
+	}
+}
+
+func (r *RaftNode) replicateTo(peer string) {
+	r.mu.Lock()
+	if r.role != raftLeader {
+		r.unlock()
+		return
+	}
+
+	next := r.nextIndex[peer]
+	if next <= r.snapshotIndex {
+		args := &InstallSnapshotArgs{
+			Term:              r.currentTerm,
+			LeaderID:          r.cfg.ID,
+			LastIncludedIndex: r.snapshotIndex,
+			LastIncludedTerm:  r.log[0].Term,
+			Value:             r.snapshotValue,
+		}
+		r.unlock()
+		r.sendSnapshot(peer, args)
+		return
+	}
+
+	prev := next - 1
+	entries := make([]RaftEntry, len(r.log[next-r.snapshotIndex:]))
+	copy(entries, r.log[next-r.snapshotIndex:])
+	args := &AppendEntriesArgs{
+		Term:         r.currentTerm,
+		LeaderID:     r.cfg.ID,
+		PrevLogIndex: prev,
+		PrevLogTerm:  r.termAtLocked(prev),
+		Entries:      entries,
+		LeaderCommit: r.commitIndex,
+	}
+	r.unlock()
+
+	reply, err := r.transport.AppendEntries(peer, args)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.unlock()
+	if reply.Term > r.currentTerm {
+		r.becomeFollowerLocked(reply.Term)
+		return
+	}
+	if r.role != raftLeader || r.currentTerm != args.Term {
+		return
+	}
+	if !reply.Success {
+		if reply.ConflictIndex > 0 && reply.ConflictIndex < r.nextIndex[peer] {
+			r.nextIndex[peer] = reply.ConflictIndex
+		}
+		return
+	}
+
+	match := args.PrevLogIndex + int64(len(args.Entries))
+	if match > r.matchIndex[peer] {
+		r.matchIndex[peer] = match
+	}
+	r.nextIndex[peer] = r.matchIndex[peer] + 1
+	r.advanceCommitLocked()
+}
+
+func (r *RaftNode) sendSnapshot(peer string, args *InstallSnapshotArgs) {
+	reply, err := r.transport.InstallSnapshot(peer, args)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.unlock()
+	if reply.Term > r.currentTerm {
+		r.becomeFollowerLocked(reply.Term)
+		return
+	}
+	if r.role != raftLeader || r.currentTerm != args.Term {
+		return
+	}
+	if args.LastIncludedIndex > r.matchIndex[peer] {
+		r.matchIndex[peer] = args.LastIncludedIndex
+	}
+	r.nextIndex[peer] = r.matchIndex[peer] + 1
+}
+
+// advanceCommitLocked commits the highest current-term entry stored on a majority.
+func (r *RaftNode) advanceCommitLocked() {
+	for index := r.lastIndexLocked(); index > r.commitIndex; index-- {
+		if r.termAtLocked(index) != r.currentTerm {
+			break
+		}
+		replicas := 1
+		for _, peer := range r.cfg.Peers {
+			if r.matchIndex[peer] >= index {
+				replicas++
+			}
+		}
+		if replicas >= r.majority() {
+			r.commitIndex = index
+			r.applyLocked()
+			return
+		}
+	}
+}
+
+// applyLocked runs committed entries against the counter and wakes waiters.
+func (r *RaftNode) applyLocked() {
+	for r.lastApplied < r.commitIndex {
+		r.lastApplied++
+		entry := r.log[r.lastApplied-r.snapshotIndex]
+
+		value := r.counter.Get()
+		if entry.Op == raftOpAdd {
+			value = r.counter.AddValue(entry.Delta)
+		}
+		if ch, ok := r.waiters[r.lastApplied]; ok {
+			ch <- raftResult{term: entry.Term, value: value}
+			delete(r.waiters, r.lastApplied)
+		}
+	}
+	r.maybeSnapshotLocked()
+}
+
+// maybeSnapshotLocked drops applied entries from the log once it grows past
+// the threshold, keeping only the counter value they add up to.
+func (r *RaftNode) maybeSnapshotLocked() {
+	if r.lastApplied-r.snapshotIndex < int64(r.cfg.SnapshotThreshold) {
+		return
+	}
+	r.compactLocked(r.lastApplied, r.counter.Get())
+}
+
+func (r *RaftNode) compactLocked(index int64, value int64) {
+	sentinel := RaftEntry{Term: r.termAtLocked(index), Op: raftOpNoop}
+	remaining := r.log[index-r.snapshotIndex+1:]
+	log := make([]RaftEntry, 0, len(remaining)+1)
+	log = append(log, sentinel)
+	r.log = append(log, remaining...)
+	r.snapshotIndex = index
+	r.snapshotValue = value
+	r.dirty = true
+}
+
+// HandleRequestVote answers a candidate's vote request.
+func (r *RaftNode) HandleRequestVote(args *RequestVoteArgs) *RequestVoteReply {
+	r.mu.Lock()
+	defer r.unlock()
+
+	if args.Term > r.currentTerm {
+		r.becomeFollowerLocked(args.Term)
+	}
+	reply := &RequestVoteReply{Term: r.currentTerm}
+	if args.Term < r.currentTerm {
+		return reply
+	}
+
+	lastIndex := r.lastIndexLocked()
+	lastTerm := r.termAtLocked(lastIndex)
+	upToDate := args.LastLogTerm > lastTerm ||
+		(args.LastLogTerm == lastTerm && args.LastLogIndex >= lastIndex)
+
+	if (r.votedFor == "" || r.votedFor == args.CandidateID) && upToDate {
+		r.votedFor = args.CandidateID
+		r.dirty = true
+		r.resetElectionDeadlineLocked()
+		reply.Granted = true
+	}
+	return reply
+}
+
+// HandleAppendEntries accepts log entries and heartbeats from the leader.
+func (r *RaftNode) HandleAppendEntries(args *AppendEntriesArgs) *AppendEntriesReply {
+	r.mu.Lock()
+	defer r.unlock()
+
+	reply := &AppendEntriesReply{Term: r.currentTerm}
+	if args.Term < r.currentTerm {
+		return reply
+	}
+	if args.Term > r.currentTerm || r.role != raftFollower {
+		r.becomeFollowerLocked(args.Term)
+	}
+	reply.Term = r.currentTerm
+	r.leaderID = args.LeaderID
+	r.resetElectionDeadlineLocked()
+
+	prev, prevTerm, entries := args.PrevLogIndex, args.PrevLogTerm, args.Entries
+	// Entries already folded into our snapshot are committed; skip them.
+	if prev < r.snapshotIndex {
+		skip := r.snapshotIndex - prev
+		if skip >= int64(len(entries)) {
+			entries = nil
+		} else {
+			entries = entries[skip:]
+		}
+		prev, prevTerm = r.snapshotIndex, r.log[0].Term
+	}
+
+	lastIndex := r.lastIndexLocked()
+	if prev > lastIndex {
+		reply.ConflictIndex = lastIndex + 1
+		return reply
+	}
+	if term := r.termAtLocked(prev); term != prevTerm {
+		first := prev
+		for first > r.snapshotIndex+1 && r.termAtLocked(first-1) == term {
+			first--
+		}
+		reply.ConflictIndex = first
+		return reply
+	}
+
+	for i, entry := range entries {
+		index := prev + 1 + int64(i)
+		if index <= r.lastIndexLocked() {
+			if r.termAtLocked(index) == entry.Term {
+				continue
+			}
+			r.log = r.log[:index-r.snapshotIndex]
+		}
+		r.log = append(r.log, entries[i:]...)
+		r.dirty = true
+		break
+	}
+
+	if args.LeaderCommit > r.commitIndex {
+		lastNew := prev + int64(len(entries))
+		r.commitIndex = min(args.LeaderCommit, lastNew)
+		r.applyLocked()
+	}
+	reply.Success = true
+	return reply
+}
+
+// HandleInstallSnapshot replaces the node's state with the leader's snapshot
+// when the node has fallen behind the leader's compacted log.
+func (r *RaftNode) HandleInstallSnapshot(args *InstallSnapshotArgs) *InstallSnapshotReply {
+	r.mu.Lock()
+	defer r.unlock()
+
+	reply := &InstallSnapshotReply{Term: r.currentTerm}
+	if args.Term < r.currentTerm {
+		return reply
+	}
+	if args.Term > r.currentTerm || r.role != raftFollower {
+		r.becomeFollowerLocked(args.Term)
+	}
+	reply.Term = r.currentTerm
+	r.leaderID = args.LeaderID
+	r.resetElectionDeadlineLocked()
+
+	if args.LastIncludedIndex <= r.snapshotIndex {
+		return reply
+	}
+
+	// Keep any log suffix that agrees with the snapshot; otherwise start over.
+	if args.LastIncludedIndex <= r.lastIndexLocked() && r.termAtLocked(args.LastIncludedIndex) == args.LastIncludedTerm {
+		r.log = r.log[args.LastIncludedIndex-r.snapshotIndex:]
+	} else {
+		r.log = []RaftEntry{{}}
+	}
+	r.log[0] = RaftEntry{Term: args.LastIncludedTerm, Op: raftOpNoop}
+	r.snapshotIndex = args.LastIncludedIndex
+	r.snapshotValue = args.Value
+	r.dirty = true
+
+	if r.lastApplied < args.LastIncludedIndex {
+		r.counter.Swap(args.Value)
+		r.lastApplied = args.LastIncludedIndex
+	}
+	if r.commitIndex < args.LastIncludedIndex {
+		r.commitIndex = args.LastIncludedIndex
+	}
+	return reply
+}
+
+// MemoryNetwork connects in-process Raft nodes and can cut nodes off to
+// simulate crashes and partitions.
+type MemoryNetwork struct {
+	mu    sync.RWMutex
+	nodes map[string]*RaftNode
+	down  map[string]bool
+}
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		nodes: make(map[string]*RaftNode),
+		down:  make(map[string]bool),
+	}
+}
+
+// Register makes node reachable by its id.
+func (n *MemoryNetwork) Register(node *RaftNode) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nodes[node.ID()] = node
+}
+
+// Disconnect drops every message to and from id.
+func (n *MemoryNetwork) Disconnect(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.down[id] = true
+}
+
+// Reconnect restores a disconnected node.
+func (n *MemoryNetwork) Reconnect(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.down, id)
+}
+
+// Transport returns the view of the network used by the node with id from.
+func (n *MemoryNetwork) Transport(from string) RaftTransport {
+	return &memoryTransport{network: n, from: from}
+}
+
+func (n *MemoryNetwork) target(from, to string) (*RaftNode, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	node, ok := n.nodes[to]
+	if !ok || n.down[from] || n.down[to] || node.stopped.Load() {
+		return nil, ErrUnreachable
+	}
+	return node, nil
+}
+
+type memoryTransport struct {
+	network *MemoryNetwork
+	from    string
+}
+
+func (t *memoryTransport) RequestVote(peer string, args *RequestVoteArgs) (*RequestVoteReply, error) {
+	node, err := t.network.target(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleRequestVote(args), nil
+}
+
+func (t *memoryTransport) AppendEntries(peer string, args *AppendEntriesArgs) (*AppendEntriesReply, error) {
+	node, err := t.network.target(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleAppendEntries(args), nil
+}
+
+func (t *memoryTransport) InstallSnapshot(peer string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error) {
+	node, err := t.network.target(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleInstallSnapshot(args), nil
+}
+
+// RaftCluster runs several nodes in one process on a MemoryNetwork and
+// routes client calls to whichever node is currently leading.
+type RaftCluster struct {
+	Network *MemoryNetwork
+	Nodes   []*RaftNode
+}
+
+// NewRaftCluster creates and starts a cluster of size nodes named
+// node-1..node-N.
+func NewRaftCluster(size int, cfg RaftConfig) (*RaftCluster, error) {
+	cluster := &RaftCluster{Network: NewMemoryNetwork()}
+
+	ids := make([]string, size)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("node-%d", i+1)
+	}
+	for _, id := range ids {
+		nodeCfg := cfg
+		nodeCfg.ID = id
+		nodeCfg.Peers = nil
+		for _, other := range ids {
+			if other != id {
+				nodeCfg.Peers = append(nodeCfg.Peers, other)
+			}
+		}
+		node := NewRaftNode(nodeCfg, cluster.Network.Transport(id))
+		cluster.Network.Register(node)
+		cluster.Nodes = append(cluster.Nodes, node)
+	}
+	for _, node := range cluster.Nodes {
+		if err := node.Start(); err != nil {
+			cluster.Stop()
+			return nil, err
+		}
+	}
+	return cluster, nil
+}
+
+// Restart stops the i-th node and starts a fresh one with the same config,
+// as a process restarting would. With a DataDir it resumes from its saved
+// state; without one it rejoins empty and catches up from the leader.
+func (c *RaftCluster) Restart(i int) error {
+	old := c.Nodes[i]
+	old.Stop()
+	node := NewRaftNode(old.cfg, c.Network.Transport(old.ID()))
+	c.Network.Register(node)
+	c.Nodes[i] = node
+	return node.Start()
+}
+
+// Stop shuts down every node.
+func (c *RaftCluster) Stop() {
+	for _, node := range c.Nodes {
+		node.Stop()
+	}
+}
+
+// Leader returns the running node that leads the highest term, if any. A
+// partitioned old leader may still think it leads, but with a stale term.
+func (c *RaftCluster) Leader() *RaftNode {
+	var leader *RaftNode
+	var leaderTerm int64
+	for _, node := range c.Nodes {
+		if node.stopped.Load() {
+			continue
+		}
+		if term, role, _ := node.State(); role == raftLeader.String() && term > leaderTerm {
+			leader, leaderTerm = node, term
+		}
+	}
+	return leader
+}
+
+func (c *RaftCluster) Increment() (int64, error) {
+	return c.do(func(n *RaftNode) (int64, error) { return n.Increment() })
+}
+
+func (c *RaftCluster) AddValue(val int64) (int64, error) {
+	return c.do(func(n *RaftNode) (int64, error) { return n.AddValue(val) })
+}
+
+func (c *RaftCluster) Get() (int64, error) {
+	return c.do(func(n *RaftNode) (int64, error) { return n.Get() })
+}
+
+// do retries op against the leader until it succeeds or a few election
+// timeouts have passed without one. Only errors that prove the entry was
+// never committed are retried; a timeout may still commit later, so retrying
+// it could apply the same increment twice.
+func (c *RaftCluster) do(op func(*RaftNode) (int64, error)) (int64, error) {
+	deadline := time.Now().Add(5 * time.Second)
+	lastErr := ErrNotLeader
+
+	for time.Now().Before(deadline) {
+		if leader := c.Leader(); leader != nil {
+			value, err := op(leader)
+			if err == nil {
+				return value, nil
+			}
+			if !errors.Is(err, ErrNotLeader) && !errors.Is(err, ErrLeadershipLost) {
+				return 0, err
+			}
+			lastErr = err
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return 0, lastErr
+}
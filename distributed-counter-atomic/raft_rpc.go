@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// raftRPCTimeout bounds dialing a peer and each call to it, so a hung peer
+// looks like a crashed one instead of holding up the caller.
+const raftRPCTimeout = 500 * time.Millisecond
+
+// RaftRPCServer exposes a RaftNode's handlers over net/rpc so nodes can run
+// as separate processes.
+type RaftRPCServer struct {
+	node *RaftNode
+}
+
+func (s *RaftRPCServer) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) error {
+	if s.node.stopped.Load() {
+		return ErrRaftStopped
+	}
+	*reply = *s.node.HandleRequestVote(args)
+	return nil
+}
+
+func (s *RaftRPCServer) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply) error {
+	if s.node.stopped.Load() {
+		return ErrRaftStopped
+	}
+	*reply = *s.node.HandleAppendEntries(args)
+	return nil
+}
+
+func (s *RaftRPCServer) InstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	if s.node.stopped.Load() {
+		return ErrRaftStopped
+	}
+	*reply = *s.node.HandleInstallSnapshot(args)
+	return nil
+}
+
+// ServeRaftRPC listens on addr and serves node's RPCs until the listener is closed.
+func ServeRaftRPC(node *RaftNode, addr string) (net.Listener, error) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Raft", &RaftRPCServer{node: node}); err != nil {
+		return nil, fmt.Errorf("unable to register raft rpc: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s: %w", addr, err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeConn(conn)
+		}
+	}()
+	return listener, nil
+}
+
+// RPCTransport reaches peers over TCP using their configured addresses.
+// Connections are dialed lazily and redialed after failures or timeouts.
+type RPCTransport struct {
+	mu      sync.Mutex
+	addrs   map[string]string
+	clients map[string]*rpc.Client
+}
+
+func NewRPCTransport(addrs map[string]string) *RPCTransport {
+	return &RPCTransport{
+		addrs:   addrs,
+		clients: make(map[string]*rpc.Client),
+	}
+}
+
+func (t *RPCTransport) call(peer, method string, args, reply interface{}) error {
+	client, err := t.client(peer)
+	if err != nil {
+		return err
+	}
+
+	call := client.Go("Raft."+method, args, reply, make(chan *rpc.Call, 1))
+	timer := time.NewTimer(raftRPCTimeout)
+	defer timer.Stop()
+	select {
+	case <-call.Done:
+		if call.Error == nil {
+			return nil
+		}
+		// An error from the handler leaves the connection usable.
+		if _, ok := call.Error.(rpc.ServerError); ok {
+			return ErrUnreachable
+		}
+	case <-timer.C:
+	}
+	// Closing the connection also releases the pending call.
+	t.drop(peer, client)
+	return ErrUnreachable
+}
+
+// client returns the connection to peer, dialing one without holding t.mu
+// so a slow peer doesn't block calls to the others.
+func (t *RPCTransport) client(peer string) (*rpc.Client, error) {
+	t.mu.Lock()
+	client, ok := t.clients[peer]
+	addr, known := t.addrs[peer]
+	t.mu.Unlock()
+	if ok {
+		return client, nil
+	}
+	if !known {
+		return nil, ErrUnreachable
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, raftRPCTimeout)
+	if err != nil {
+		return nil, ErrUnreachable
+	}
+	client = rpc.NewClient(conn)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if existing, ok := t.clients[peer]; ok {
+		// Another call dialed first; use its connection.
+		client.Close()
+		return existing, nil
+	}
+	t.clients[peer] = client
+	return client, nil
+}
+
+func (t *RPCTransport) drop(peer string, client *rpc.Client) {
+	t.mu.Lock()
+	if t.clients[peer] == client {
+		delete(t.clients, peer)
+	}
+	t.mu.Unlock()
+	client.Close()
+}
+
+func (t *RPCTransport) RequestVote(peer string, args *RequestVoteArgs) (*RequestVoteReply, error) {
+	reply := &RequestVoteReply{}
+	return reply, t.call(peer, "RequestVote", args, reply)
+}
+
+func (t *RPCTransport) AppendEntries(peer string, args *AppendEntriesArgs) (*AppendEntriesReply, error) {
+	reply := &AppendEntriesReply{}
+	return reply, t.call(peer, "AppendEntries", args, reply)
+}
+
+func (t *RPCTransport) InstallSnapshot(peer string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error) {
+	reply := &InstallSnapshotReply{}
+	return reply, t.call(peer, "InstallSnapshot", args, reply)
+}
+
+// runRaftProcess runs one cluster member in this process. members lists every
+// node as id=addr, and commands ("incr", "decr", "add N", "get") are read
+// from stdin. The node's term, vote and log are kept under raft-data so it
+// can be restarted, e.g.:
+//
+//	go run . raft node-1 node-1=127.0.0.1:7201 node-2=127.0.0.1:7202 node-3=127.0.0.1:7203
+func runRaftProcess(id string, members []string) error {
+	addrs := make(map[string]string)
+	var peers []string
+	var selfAddr string
+	for _, member := range members {
+		name, addr, ok := strings.Cut(member, "=")
+		if !ok {
+			return fmt.Errorf("invalid member %q, expected id=addr", member)
+		}
+		if name == id {
+			selfAddr = addr
+			continue
+		}
+		addrs[name] = addr
+		peers = append(peers, name)
+	}
+	if selfAddr == "" {
+		return fmt.Errorf("node %s is not in the member list", id)
+	}
+
+	node := NewRaftNode(RaftConfig{ID: id, Peers: peers, DataDir: "raft-data"}, NewRPCTransport(addrs))
+	// Load the saved state before answering any RPC.
+	if err := node.Start(); err != nil {
+		return err
+	}
+	defer node.Stop()
+	listener, err := ServeRaftRPC(node, selfAddr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	fmt.Printf("Raft node %s listening on %s\n", id, selfAddr)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		var value int64
+		switch fields[0] {
+		case "incr":
+			value, err = node.Increment()
+		case "decr":
+			value, err = node.Decrement()
+		case "get":
+			value, err = node.Get()
+		case "add":
+			if len(fields) != 2 {
+				err = fmt.Errorf("usage: add N")
+				break
+			}
+			var delta int64
+			delta, err = strconv.ParseInt(fields[1], 10, 64)
+			if err == nil {
+				value, err = node.AddValue(delta)
+			}
+		default:
+			err = fmt.Errorf("unknown command %q", fields[0])
+		}
+
+		if err != nil {
+			_, _, leader := node.State()
+			fmt.Printf("error: %s (leader: %s)\n", err, leader)
+			continue
+		}
+		fmt.Println(value)
+	}
+	return scanner.Err()
+}
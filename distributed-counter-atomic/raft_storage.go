@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// raftState is what a node must not forget across a restart: its term and
+// vote, so it never votes twice in one term, and its log and snapshot, so
+// entries it acknowledged stay committed.
+type raftState struct {
+	Term          int64       `json:"term"`
+	VotedFor      string      `json:"voted_for,omitempty"`
+	SnapshotIndex int64       `json:"snapshot_index"`
+	SnapshotTerm  int64       `json:"snapshot_term"`
+	SnapshotValue int64       `json:"snapshot_value"`
+	Log           []RaftEntry `json:"log"` // entries after the snapshot
+}
+
+// statePath is where the node's state lives, or "" if it isn't persisted.
+func (r *RaftNode) statePath() string {
+	if r.cfg.DataDir == "" {
+		return ""
+	}
+	return filepath.Join(r.cfg.DataDir, "raft-"+r.cfg.ID+".json")
+}
+
+// loadStateLocked restores the state saved by an earlier run, if any.
+func (r *RaftNode) loadStateLocked() error {
+	path := r.statePath()
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read raft state %s: %w", path, err)
+	}
+	var state raftState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("invalid raft state %s: %w", path, err)
+	}
+
+	r.currentTerm = state.Term
+	r.votedFor = state.VotedFor
+	r.log = append([]RaftEntry{{Term: state.SnapshotTerm, Op: raftOpNoop}}, state.Log...)
+	r.snapshotIndex = state.SnapshotIndex
+	r.snapshotValue = state.SnapshotValue
+	// Entries past the snapshot are re-applied once the leader says they
+	// are committed.
+	r.commitIndex = state.SnapshotIndex
+	r.lastApplied = state.SnapshotIndex
+	r.counter.Swap(state.SnapshotValue)
+	return nil
+}
+
+// persistLocked writes the state out and fsyncs it if it changed since the
+// last write. A node that can no longer persist could break its promises
+// to the cluster, so failing to write is fatal.
+func (r *RaftNode) persistLocked() {
+	if !r.dirty {
+		return
+	}
+	r.dirty = false
+	path := r.statePath()
+	if path == "" || r.stopped.Load() {
+		return
+	}
+
+	state := raftState{
+		Term:          r.currentTerm,
+		VotedFor:      r.votedFor,
+		SnapshotIndex: r.snapshotIndex,
+		SnapshotTerm:  r.log[0].Term,
+		SnapshotValue: r.snapshotValue,
+		Log:           r.log[1:],
+	}
+	if err := writeFileSync(path, state); err != nil {
+		panic(fmt.Sprintf("raft: unable to persist state for %s: %s", r.cfg.ID, err))
+	}
+}
+
+// writeFileSync replaces path with v as JSON, syncing the file and its
+// directory so the write survives a crash.
+func writeFileSync(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestCluster(t *testing.T, cfg RaftConfig) *RaftCluster {
+	t.Helper()
+	cluster, err := NewRaftCluster(3, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Stop)
+	return cluster
+}
+
+func mustAdd(t *testing.T, cluster *RaftCluster, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := cluster.Increment(); err != nil {
+			t.Fatalf("increment %d: %s", i, err)
+		}
+	}
+}
+
+func mustGet(t *testing.T, cluster *RaftCluster, want int64) {
+	t.Helper()
+	got, err := cluster.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("counter = %d, want %d", got, want)
+	}
+}
+
+// waitFor polls cond until it holds or a few election timeouts pass.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRaftLeaderCrash(t *testing.T) {
+	cluster := newTestCluster(t, RaftConfig{})
+	mustAdd(t, cluster, 10)
+
+	old := cluster.Leader()
+	old.Stop()
+	waitFor(t, "a new leader", func() bool {
+		leader := cluster.Leader()
+		return leader != nil && leader != old
+	})
+	mustAdd(t, cluster, 5)
+	mustGet(t, cluster, 15)
+}
+
+func TestRaftPartitionHeal(t *testing.T) {
+	cluster := newTestCluster(t, RaftConfig{})
+	mustAdd(t, cluster, 10)
+
+	old := cluster.Leader()
+	cluster.Network.Disconnect(old.ID())
+	// The isolated leader can't reach a majority, so nothing it accepts
+	// commits.
+	if _, err := old.Increment(); err == nil {
+		t.Fatal("minority leader committed an increment")
+	}
+	waitFor(t, "a new leader", func() bool {
+		leader := cluster.Leader()
+		return leader != nil && leader != old
+	})
+	mustAdd(t, cluster, 5)
+
+	cluster.Network.Reconnect(old.ID())
+	waitFor(t, "the old leader to step down", func() bool {
+		_, role, _ := old.State()
+		return role != raftLeader.String()
+	})
+	mustGet(t, cluster, 15)
+}
+
+func TestRaftSnapshotCatchUp(t *testing.T) {
+	cluster := newTestCluster(t, RaftConfig{SnapshotThreshold: 20})
+	mustAdd(t, cluster, 5)
+
+	var lagging *RaftNode
+	for _, node := range cluster.Nodes {
+		if node != cluster.Leader() {
+			lagging = node
+			break
+		}
+	}
+	cluster.Network.Disconnect(lagging.ID())
+	mustAdd(t, cluster, 100)
+
+	// The leader has compacted past everything the lagging node has, so it
+	// can only catch up from a snapshot.
+	leader := cluster.Leader()
+	leader.mu.Lock()
+	compacted := leader.snapshotIndex
+	leader.mu.Unlock()
+	if compacted <= 5 {
+		t.Fatalf("leader did not compact its log (snapshot index %d)", compacted)
+	}
+
+	cluster.Network.Reconnect(lagging.ID())
+	waitFor(t, "the lagging node to catch up", func() bool {
+		return lagging.counter.Get() >= 105
+	})
+	mustGet(t, cluster, 105)
+}
+
+func TestRaftRestartKeepsState(t *testing.T) {
+	cluster := newTestCluster(t, RaftConfig{DataDir: t.TempDir(), SnapshotThreshold: 20})
+	mustAdd(t, cluster, 30)
+
+	// Take the whole cluster down at once, so only what is on disk survives.
+	terms := make([]int64, len(cluster.Nodes))
+	for i, node := range cluster.Nodes {
+		terms[i], _, _ = node.State()
+		node.Stop()
+	}
+	for i := range cluster.Nodes {
+		if err := cluster.Restart(i); err != nil {
+			t.Fatal(err)
+		}
+		if term, _, _ := cluster.Nodes[i].State(); term < terms[i] {
+			t.Fatalf("node %d came back in term %d, had %d", i, term, terms[i])
+		}
+	}
+	mustAdd(t, cluster, 1)
+	mustGet(t, cluster, 31)
+}
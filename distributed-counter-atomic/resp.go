@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var errRESPProtocol = errors.New("protocol error")
+
+// Limits on what a client may send. Keys and values are counter names and
+// numbers, so these are generous while keeping a client from making the
+// server allocate much before any data arrives.
+const (
+	maxRESPArgs   = 1024
+	maxRESPBulk   = 64 * 1024
+	maxRESPInline = 64 * 1024
+)
+
+// RESPServer serves named counters over the Redis protocol, so redis-cli
+// and Redis client libraries can INCR, GET and SET them.
+type RESPServer struct {
+	mu       sync.RWMutex
+	counters map[string]*Counter
+
+	listenerMu sync.Mutex
+	listener   net.Listener
+	conns      map[net.Conn]struct{}
+	closed     bool
+	wg         sync.WaitGroup
+}
+
+func NewRESPServer() *RESPServer {
+	return &RESPServer{
+		counters: make(map[string]*Counter),
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+// Counter returns the counter stored under name, creating it if needed, so
+// the same instance can be watched or exported as a metric.
+func (s *RESPServer) Counter(name string) *Counter {
+	s.mu.RLock()
+	c, ok := s.counters[name]
+	s.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.counters[name]; ok {
+		return c
+	}
+	c = NewCounter()
+	s.counters[name] = c
+	return c
+}
+
+// ListenAndServe accepts clients on addr until Close is called.
+func (s *RESPServer) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %w", addr, err)
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts clients on listener until Close is called. It closes
+// listener and returns at once if Close was called already.
+func (s *RESPServer) Serve(listener net.Listener) error {
+	s.listenerMu.Lock()
+	if s.closed {
+		s.listenerMu.Unlock()
+		listener.Close()
+		return nil
+	}
+	s.listener = listener
+	s.listenerMu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		s.listenerMu.Lock()
+		if s.closed {
+			// Accepted just as Close ran; it won't see this one.
+			s.listenerMu.Unlock()
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.listenerMu.Unlock()
+		go s.handleConn(conn)
+	}
+}
+
+// Close stops accepting clients and disconnects the ones already connected.
+func (s *RESPServer) Close() error {
+	s.listenerMu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.listenerMu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *RESPServer) handleConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.listenerMu.Lock()
+		delete(s.conns, conn)
+		s.listenerMu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		args, err := readRESPCommand(reader)
+		if err != nil {
+			if errors.Is(err, errRESPProtocol) {
+				writeRESPError(writer, "ERR "+err.Error())
+				writer.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := s.execute(writer, args)
+		// Pipelined commands are answered together once the input runs dry.
+		if reader.Buffered() == 0 || quit {
+			if err := writer.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// execute runs one command and writes its reply. It reports whether the
+// client asked to close the connection.
+func (s *RESPServer) execute(w *bufio.Writer, args []string) bool {
+	name := strings.ToUpper(args[0])
+	switch name {
+	case "PING":
+		if len(args) > 1 {
+			writeRESPBulk(w, args[1])
+		} else {
+			w.WriteString("+PONG\r\n")
+		}
+	case "QUIT":
+		w.WriteString("+OK\r\n")
+		return true
+	case "COMMAND":
+		// redis-cli asks for command docs on connect; an empty list is fine.
+		w.WriteString("*0\r\n")
+	case "INCR", "DECR":
+		if !checkArity(w, args, 2) {
+			break
+		}
+		delta := int64(1)
+		if name == "DECR" {
+			delta = -1
+		}
+		s.incrBy(w, args[1], delta)
+	case "INCRBY", "DECRBY":
+		if !checkArity(w, args, 3) {
+			break
+		}
+		delta, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil || (name == "DECRBY" && delta == math.MinInt64) {
+			writeRESPError(w, "ERR value is not an integer or out of range")
+			break
+		}
+		if name == "DECRBY" {
+			delta = -delta
+		}
+		s.incrBy(w, args[1], delta)
+	case "GET":
+		if !checkArity(w, args, 2) {
+			break
+		}
+		s.mu.RLock()
+		c, ok := s.counters[args[1]]
+		s.mu.RUnlock()
+		if !ok {
+			w.WriteString("$-1\r\n")
+			break
+		}
+		writeRESPBulk(w, strconv.FormatInt(c.Get(), 10))
+	case "SET":
+		if !checkArity(w, args, 3) {
+			break
+		}
+		value, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			writeRESPError(w, "ERR value is not an integer or out of range")
+			break
+		}
+		s.update(args[1], func(c *Counter) { c.Swap(value) })
+		w.WriteString("+OK\r\n")
+	case "DEL":
+		if len(args) < 2 {
+			writeRESPError(w, "ERR wrong number of arguments for 'del' command")
+			break
+		}
+		deleted := 0
+		s.mu.Lock()
+		for _, key := range args[1:] {
+			if _, ok := s.counters[key]; ok {
+				delete(s.counters, key)
+				deleted++
+			}
+		}
+		s.mu.Unlock()
+		fmt.Fprintf(w, ":%d\r\n", deleted)
+	case "KEYS":
+		if !checkArity(w, args, 2) {
+			break
+		}
+		s.mu.RLock()
+		var keys []string
+		for key := range s.counters {
+			if globMatch(args[1], key) {
+				keys = append(keys, key)
+			}
+		}
+		s.mu.RUnlock()
+		sort.Strings(keys)
+		fmt.Fprintf(w, "*%d\r\n", len(keys))
+		for _, key := range keys {
+			writeRESPBulk(w, key)
+		}
+	default:
+		writeRESPError(w, fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+	return false
+}
+
+// incrBy adds delta to key, refusing to wrap around like Redis does.
+func (s *RESPServer) incrBy(w *bufio.Writer, key string, delta int64) {
+	var next int64
+	overflow := false
+	s.update(key, func(c *Counter) {
+		for {
+			current := c.Get()
+			next = current + delta
+			if (delta > 0 && next < current) || (delta < 0 && next > current) {
+				overflow = true
+				return
+			}
+			if c.CompareAndSwap(current, next) {
+				return
+			}
+		}
+	})
+	if overflow {
+		writeRESPError(w, "ERR increment or decrement would overflow")
+		return
+	}
+	fmt.Fprintf(w, ":%d\r\n", next)
+}
+
+// update runs fn on key's counter, creating it if needed. fn runs under the
+// read lock, so a concurrent DEL can't remove the counter midway and lose
+// the update.
+func (s *RESPServer) update(key string, fn func(c *Counter)) {
+	for {
+		s.mu.RLock()
+		if c, ok := s.counters[key]; ok {
+			fn(c)
+			s.mu.RUnlock()
+			return
+		}
+		s.mu.RUnlock()
+		s.Counter(key)
+	}
+}
+
+func checkArity(w *bufio.Writer, args []string, want int) bool {
+	if len(args) != want {
+		writeRESPError(w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(args[0])))
+		return false
+	}
+	return true
+}
+
+func writeRESPError(w *bufio.Writer, msg string) {
+	w.WriteString("-" + msg + "\r\n")
+}
+
+func writeRESPBulk(w *bufio.Writer, value string) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(value), value)
+}
+
+// readRESPCommand reads either a RESP array of bulk strings, as sent by
+// clients, or an inline command typed into telnet or nc.
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || count < 0 || count > maxRESPArgs {
+		return nil, fmt.Errorf("invalid multibulk length: %w", errRESPProtocol)
+	}
+	args := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		header, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(header) == 0 || header[0] != '$' {
+			return nil, fmt.Errorf("expected '$', got %q: %w", header, errRESPProtocol)
+		}
+		size, err := strconv.Atoi(header[1:])
+		if err != nil || size < 0 || size > maxRESPBulk {
+			return nil, fmt.Errorf("invalid bulk length: %w", errRESPProtocol)
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, fmt.Errorf("bulk string not terminated by CRLF: %w", errRESPProtocol)
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// readRESPLine reads one CRLF-terminated line of at most maxRESPInline bytes.
+func readRESPLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxRESPInline {
+			return "", fmt.Errorf("line too long: %w", errRESPProtocol)
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+// globMatch implements the Redis KEYS pattern syntax: *, ?, [abc], [^a],
+// [a-z] and backslash escapes. Unlike path.Match, '/' is not special.
+//
+// On a mismatch the last '*' takes one more byte of key and matching goes on
+// from just after it. Earlier stars never need revisiting, so a pattern full
+// of stars costs at most len(pattern)*len(key) steps rather than exponential
+// time.
+func globMatch(pattern, key string) bool {
+	p, k := 0, 0
+	starP, starK := -1, 0
+	for k < len(key) {
+		if p < len(pattern) && pattern[p] == '*' {
+			p++
+			starP, starK = p, k
+			continue
+		}
+		if p < len(pattern) {
+			if width, ok := matchOne(pattern[p:], key[k]); ok {
+				p, k = p+width, k+1
+				continue
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		starK++
+		p, k = starP, starK
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchOne matches c against the single-byte element pattern starts with
+// (?, a class, an escape or a literal) and returns the element's length.
+func matchOne(pattern string, c byte) (int, bool) {
+	switch pattern[0] {
+	case '?':
+		return 1, true
+	case '[':
+		rest, ok := matchClass(pattern[1:], c)
+		return len(pattern) - len(rest), ok
+	case '\\':
+		if len(pattern) > 1 {
+			return 2, pattern[1] == c
+		}
+	}
+	return 1, pattern[0] == c
+}
+
+// matchClass matches c against a [...] class whose body starts at pattern and
+// returns the pattern after the closing bracket.
+func matchClass(pattern string, c byte) (string, bool) {
+	negate := false
+	if len(pattern) > 0 && pattern[0] == '^' {
+		negate = true
+		pattern = pattern[1:]
+	}
+
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		lo := pattern[0]
+		if lo == '\\' && len(pattern) > 1 {
+			pattern = pattern[1:]
+			lo = pattern[0]
+		}
+		pattern = pattern[1:]
+
+		hi := lo
+		if len(pattern) > 1 && pattern[0] == '-' && pattern[1] != ']' {
+			hi = pattern[1]
+			pattern = pattern[2:]
+		}
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if c >= lo && c <= hi {
+			matched = true
+		}
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:] // closing bracket
+	}
+	return pattern, matched != negate
+}
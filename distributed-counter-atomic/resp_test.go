@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"*", "", true},
+		{"*", "hits", true},
+		{"h*s", "hits", true},
+		{"h*s", "hit", false},
+		{"h?ts", "hits", true},
+		{"h?ts", "hts", false},
+		{"h[ai]ts", "hits", true},
+		{"h[^i]ts", "hits", false},
+		{"h[a-j]ts", "hits", true},
+		{"h[j-a]ts", "hits", true},
+		{"h[k-z]ts", "hits", false},
+		{`h\*ts`, "h*ts", true},
+		{`h\*ts`, "hits", false},
+		{`hits\`, `hits\`, true},
+		{"user:*:views", "user:42:views", true},
+		{"user:*:views", "user:42:clicks", false},
+		{"a/*", "a/b/c", true},
+		{"*a*b", "xaxxb", true},
+		{"*a*b", "xaxxbx", false},
+		{"**b", "b", true},
+		{"a*", "", false},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestGlobMatchManyStars(t *testing.T) {
+	pattern := strings.Repeat("*a", 30) + "*b"
+	key := strings.Repeat("a", 1000)
+
+	done := make(chan bool)
+	go func() { done <- globMatch(pattern, key) }()
+	select {
+	case matched := <-done:
+		if matched {
+			t.Fatal("matched a key without a b")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("globMatch backtracks exponentially")
+	}
+}
+
+// respClient sends inline commands and reads back each reply as one line;
+// the elements of an array reply follow its header, space separated.
+type respClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialRESP(t *testing.T) *respClient {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewRESPServer()
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &respClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *respClient) do(command string) string {
+	c.t.Helper()
+	fmt.Fprintf(c.conn, "%s\r\n", command)
+	return c.reply()
+}
+
+func (c *respClient) reply() string {
+	c.t.Helper()
+	line := c.line()
+	switch {
+	case strings.HasPrefix(line, "$") && line != "$-1":
+		return c.line()
+	case strings.HasPrefix(line, "*"):
+		n, _ := strconv.Atoi(line[1:])
+		for i := 0; i < n; i++ {
+			line += " " + c.reply()
+		}
+	}
+	return line
+}
+
+func (c *respClient) line() string {
+	c.t.Helper()
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+func TestRESPCommands(t *testing.T) {
+	client := dialRESP(t)
+	steps := []struct {
+		command, want string
+	}{
+		{"PING", "+PONG"},
+		{"INCR hits", ":1"},
+		{"INCRBY hits 41", ":42"},
+		{"DECR hits", ":41"},
+		{"GET hits", "41"},
+		{"SET hits 9223372036854775807", "+OK"},
+		{"INCR hits", "-ERR increment or decrement would overflow"},
+		{"DECRBY hits -9223372036854775808", "-ERR value is not an integer or out of range"},
+		{"DEL hits missing", ":1"},
+		{"GET hits", "$-1"},
+		{"INCR hits", ":1"},
+		{"SET misses 3", "+OK"},
+		{"KEYS *s", "*2 hits misses"},
+		{"KEYS h*", "*1 hits"},
+		{"GET", "-ERR wrong number of arguments for 'get' command"},
+		{"NOPE", "-ERR unknown command 'NOPE'"},
+	}
+	for _, step := range steps {
+		if got := client.do(step.command); got != step.want {
+			t.Errorf("%s = %q, want %q", step.command, got, step.want)
+		}
+	}
+}
+
+func TestRESPCloseBeforeServe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewRESPServer()
+	server.Close()
+
+	done := make(chan error)
+	go func() { done <- server.Serve(listener) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve kept running after Close")
+	}
+	if conn, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		conn.Close()
+		t.Fatal("listener still accepts connections")
+	}
+}
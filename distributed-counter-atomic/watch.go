@@ -0,0 +1,189 @@
+package main
+
+import "sync"
+
+type WatchDirection int
+
+const (
+	WatchUp   WatchDirection = 1 << iota // value rose to or above the threshold
+	WatchDown                            // value fell below the threshold
+	WatchBoth = WatchUp | WatchDown
+)
+
+// WatchEvent reports a change seen by a watcher. Crossed is WatchUp or
+// WatchDown for threshold watchers and zero for plain change watchers.
+type WatchEvent struct {
+	Previous int64
+	Value    int64
+	Crossed  WatchDirection
+}
+
+type watcher struct {
+	threshold  bool
+	level      int64
+	directions WatchDirection
+	above      bool
+	last       int64
+	events     chan WatchEvent
+}
+
+// watchHub fans counter updates out to watchers. Updates only poke signal
+// without blocking; a single goroutine then reads the current value and
+// evaluates every watcher, so bursts of increments collapse into one check
+// and the hot path never waits on a slow reader. The hub stops once its
+// last watcher is cancelled; the next watch starts a new one.
+type watchHub struct {
+	counter  *Counter
+	signal   chan struct{}
+	stop     chan struct{}
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+	closed   bool
+}
+
+// Watch returns a channel that receives an event whenever the value
+// changes, and a function that stops the watch and closes the channel.
+// A slow reader does not see every intermediate value, but the event
+// waiting in the channel always carries the latest one.
+func (c *Counter) Watch() (<-chan WatchEvent, func()) {
+	return c.addWatcher(&watcher{})
+}
+
+// WatchThreshold returns a channel that receives an event each time the
+// value crosses level in one of the given directions. "Up" means reaching
+// level or more from below it. Updates are coalesced, so a crossing undone
+// before the watcher checks the value (19 -> 21 -> 19 with level 20) goes
+// unreported; watch for the value being past level, not for every crossing.
+func (c *Counter) WatchThreshold(level int64, directions WatchDirection) (<-chan WatchEvent, func()) {
+	return c.addWatcher(&watcher{threshold: true, level: level, directions: directions})
+}
+
+// addWatcher registers w with a running hub, retrying if the hub it found
+// was shutting down.
+func (c *Counter) addWatcher(w *watcher) (<-chan WatchEvent, func()) {
+	for {
+		if events, cancel, ok := c.hub().add(w); ok {
+			return events, cancel
+		}
+	}
+}
+
+// notify wakes the watch hub, if there is one, without ever blocking.
+func (c *Counter) notify() {
+	if h := c.watchers.Load(); h != nil {
+		select {
+		case h.signal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// hub returns the counter's watch hub, creating it on first use.
+func (c *Counter) hub() *watchHub {
+	if h := c.watchers.Load(); h != nil {
+		return h
+	}
+	h := &watchHub{
+		counter:  c,
+		signal:   make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		watchers: make(map[*watcher]struct{}),
+	}
+	if c.watchers.CompareAndSwap(nil, h) {
+		go h.run()
+		return h
+	}
+	return c.watchers.Load()
+}
+
+func (h *watchHub) add(w *watcher) (<-chan WatchEvent, func(), bool) {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil, nil, false
+	}
+	w.events = make(chan WatchEvent, 1)
+	w.last = h.counter.Get()
+	w.above = w.last >= w.level
+	h.watchers[w] = struct{}{}
+	h.mu.Unlock()
+	// Catch any update that raced with registration.
+	h.counter.notify()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.watchers, w)
+			if len(h.watchers) == 0 {
+				// Let the hub and its goroutine go; the counter no longer
+				// points at it, so the next watch creates a fresh one.
+				h.closed = true
+				h.counter.watchers.CompareAndSwap(h, nil)
+				close(h.stop)
+			}
+			h.mu.Unlock()
+			close(w.events)
+		})
+	}
+	return w.events, cancel, true
+}
+
+func (h *watchHub) run() {
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-h.signal:
+		}
+		value := h.counter.Get()
+		h.mu.Lock()
+		for w := range h.watchers {
+			w.observe(value)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// observe compares value with what the watcher last saw. Only the hub
+// goroutine calls it, and always with h.mu held.
+func (w *watcher) observe(value int64) {
+	previous := w.last
+	if value == previous {
+		return
+	}
+	w.last = value
+
+	if !w.threshold {
+		w.deliver(WatchEvent{Previous: previous, Value: value})
+		return
+	}
+
+	above := value >= w.level
+	if above == w.above {
+		return
+	}
+	w.above = above
+	crossed := WatchDown
+	if above {
+		crossed = WatchUp
+	}
+	if w.directions&crossed != 0 {
+		w.deliver(WatchEvent{Previous: previous, Value: value, Crossed: crossed})
+	}
+}
+
+// deliver replaces any unread event with ev, so the channel holds the newest.
+// The hub is the only sender, so the second send cannot block.
+func (w *watcher) deliver(ev WatchEvent) {
+	select {
+	case w.events <- ev:
+		return
+	default:
+	}
+	select {
+	case <-w.events:
+	default:
+	}
+	w.events <- ev
+}
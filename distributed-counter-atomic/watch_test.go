@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatcherObserveThreshold(t *testing.T) {
+	tests := []struct {
+		name       string
+		start      int64
+		directions WatchDirection
+		values     []int64
+		want       []WatchDirection
+	}{
+		{"up", 10, WatchUp, []int64{19, 20, 25, 15}, []WatchDirection{WatchUp}},
+		{"down", 10, WatchDown, []int64{20, 19, 21}, []WatchDirection{WatchDown}},
+		{"both", 10, WatchBoth, []int64{20, 19, 21}, []WatchDirection{WatchUp, WatchDown, WatchUp}},
+		{"starting above", 30, WatchUp, []int64{25, 20}, nil},
+		{"no change", 10, WatchBoth, []int64{10, 10}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &watcher{threshold: true, level: 20, directions: tt.directions, last: tt.start, above: tt.start >= 20}
+			w.events = make(chan WatchEvent, 1)
+			var got []WatchDirection
+			for _, value := range tt.values {
+				w.observe(value)
+				select {
+				case ev := <-w.events:
+					got = append(got, ev.Crossed)
+				default:
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("crossings = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("crossings = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWatchKeepsLatestValue(t *testing.T) {
+	c := NewCounter()
+	events, cancel := c.Watch()
+	defer cancel()
+
+	// Nobody reads while the value moves; the waiting event must end up
+	// carrying the last value, not the first.
+	for i := 0; i < 100; i++ {
+		c.Increment()
+	}
+	deadline := time.After(3 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Value == 100 {
+				return
+			}
+		case <-deadline:
+			t.Fatal("never saw the value reach 100")
+		}
+	}
+}
+
+func TestWatchThresholdCrossing(t *testing.T) {
+	c := NewCounter()
+	events, cancel := c.WatchThreshold(5, WatchBoth)
+	defer cancel()
+
+	c.AddValue(7)
+	if ev := nextEvent(t, events); ev.Crossed != WatchUp || ev.Value != 7 {
+		t.Errorf("event = %+v, want an upward crossing at 7", ev)
+	}
+	c.AddValue(-3)
+	if ev := nextEvent(t, events); ev.Crossed != WatchDown || ev.Value != 4 {
+		t.Errorf("event = %+v, want a downward crossing at 4", ev)
+	}
+}
+
+func TestWatchHubStopsAfterLastCancel(t *testing.T) {
+	c := NewCounter()
+	first, cancelFirst := c.Watch()
+	_, cancelSecond := c.Watch()
+	hub := c.watchers.Load()
+
+	cancelFirst()
+	cancelFirst()
+	if _, ok := <-first; ok {
+		t.Error("cancelled watch channel still open")
+	}
+	if c.watchers.Load() != hub {
+		t.Fatal("hub dropped while a watcher remained")
+	}
+	cancelSecond()
+	if c.watchers.Load() != nil {
+		t.Fatal("hub kept after the last watcher was cancelled")
+	}
+	select {
+	case <-hub.stop:
+	default:
+		t.Error("hub goroutine not told to stop")
+	}
+
+	// A later watch starts a fresh hub that still sees updates.
+	events, cancel := c.Watch()
+	defer cancel()
+	if c.watchers.Load() == hub {
+		t.Error("new watch reused the stopped hub")
+	}
+	c.Increment()
+	if ev := nextEvent(t, events); ev.Value != 1 {
+		t.Errorf("event = %+v, want value 1", ev)
+	}
+}
+
+func nextEvent(t *testing.T, events <-chan WatchEvent) WatchEvent {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for a watch event")
+		return WatchEvent{}
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HostConfig holds the settings for every request to one host. Credentials
+// are sent as a bearer Token if set, otherwise as basic auth.
+type HostConfig struct {
+	Username string            `json:"username,omitempty"`
+	Password string            `json:"password,omitempty"`
+	Token    string            `json:"token,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	// Proxy overrides the downloader's proxy for this host; "direct"
+	// connects without one.
+	Proxy string `json:"proxy,omitempty"`
+}
+
+// String keeps credentials out of anything that prints a HostConfig.
+func (c HostConfig) String() string {
+	auth := "none"
+	if c.Token != "" {
+		auth = "bearer"
+	} else if c.Username != "" {
+		auth = "basic as " + c.Username
+	}
+	return fmt.Sprintf("{auth: %s, headers: %d, proxy: %s}", auth, len(c.Headers), redactURL(c.Proxy))
+}
+
+// netrcEntry is one machine (or the default) from a .netrc file.
+type netrcEntry struct {
+	login, password string
+}
+
+// SetHostConfig sets what is sent to host, which is a host name, a
+// host:port, or "*" for hosts with no settings of their own.
+func (d *Downloader) SetHostConfig(host string, cfg HostConfig) error {
+	if cfg.Proxy != "" && cfg.Proxy != "direct" {
+		if _, err := parseProxy(cfg.Proxy); err != nil {
+			return err
+		}
+	}
+	d.optionsMu.Lock()
+	defer d.optionsMu.Unlock()
+	d.hosts[strings.ToLower(host)] = cfg
+	return nil
+}
+
+// HostConfig returns the settings set for exactly host.
+func (d *Downloader) HostConfig(host string) HostConfig {
+	d.optionsMu.RLock()
+	defer d.optionsMu.RUnlock()
+	return d.hosts[strings.ToLower(host)]
+}
+
+// LoadHostConfigs reads a JSON object mapping hosts to their HostConfig.
+func (d *Downloader) LoadHostConfigs(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read host config %s: %s", path, err)
+	}
+	var hosts map[string]HostConfig
+	if err := json.Unmarshal(data, &hosts); err != nil {
+		return fmt.Errorf("invalid host config %s: %s", path, err)
+	}
+	for host, cfg := range hosts {
+		if err := d.SetHostConfig(host, cfg); err != nil {
+			return fmt.Errorf("invalid host config %s: %s: %s", path, host, err)
+		}
+	}
+	return nil
+}
+
+// SetProxy sends requests through proxyURL, unless a host has its own.
+// An empty proxyURL goes back to HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+func (d *Downloader) SetProxy(proxyURL string) error {
+	var proxy *url.URL
+	if proxyURL != "" {
+		var err error
+		if proxy, err = parseProxy(proxyURL); err != nil {
+			return err
+		}
+	}
+	d.optionsMu.Lock()
+	defer d.optionsMu.Unlock()
+	d.proxy = proxy
+	return nil
+}
+
+func parseProxy(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err == nil && (u.Scheme == "" || u.Host == "") {
+		err = fmt.Errorf("want scheme://host:port")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %s: %s", redactURL(proxyURL), err)
+	}
+	return u, nil
+}
+
+// defaultNetrcPath is $NETRC, or .netrc in the home directory.
+func defaultNetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// LoadNetrc reads login and password for hosts without credentials of their
+// own from a .netrc file. macdef entries are skipped.
+func (d *Downloader) LoadNetrc(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to read netrc %s: %s", path, err)
+	}
+	defer file.Close()
+
+	machines := make(map[string]netrcEntry)
+	var machine string
+	var entry *netrcEntry
+	save := func() {
+		if entry != nil {
+			machines[machine] = *entry
+		}
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			value := ""
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+			switch fields[i] {
+			case "machine":
+				save()
+				machine, entry = strings.ToLower(value), &netrcEntry{}
+				i++
+			case "default":
+				save()
+				machine, entry = "*", &netrcEntry{}
+			case "login":
+				if entry != nil {
+					entry.login = value
+				}
+				i++
+			case "password":
+				if entry != nil {
+					entry.password = value
+				}
+				i++
+			case "account":
+				i++
+			case "macdef":
+				// A macro runs until the next blank line.
+				save()
+				entry = nil
+				for scanner.Scan() && strings.TrimSpace(scanner.Text()) != "" {
+				}
+				i = len(fields)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("unable to read netrc %s: %s", path, err)
+	}
+	save()
+
+	d.optionsMu.Lock()
+	defer d.optionsMu.Unlock()
+	d.netrc = machines
+	return nil
+}
+
+// hostConfigLocked returns the settings for u's host, trying host:port,
+// then the bare host name, then "*".
+func (d *Downloader) hostConfigLocked(u *url.URL) HostConfig {
+	for _, key := range []string{strings.ToLower(u.Host), strings.ToLower(u.Hostname())} {
+		if cfg, ok := d.hosts[key]; ok {
+			return cfg
+		}
+	}
+	return d.hosts["*"]
+}
+
+// hostHeadersLocked returns the headers for u's host followed by those
+// for "*", which every host gets.
+func (d *Downloader) hostHeadersLocked(u *url.URL) []map[string]string {
+	return []map[string]string{d.hostConfigLocked(u).Headers, d.hosts["*"].Headers}
+}
+
+// authorize adds the headers and credentials configured for req's host.
+// Headers already on req win, and credentials in the URL itself are left
+// for net/http to send.
+func (d *Downloader) authorize(req *http.Request) {
+	d.optionsMu.RLock()
+	defer d.optionsMu.RUnlock()
+
+	for _, headers := range d.hostHeadersLocked(req.URL) {
+		for key, value := range headers {
+			if req.Header.Get(key) == "" {
+				req.Header.Set(key, value)
+			}
+		}
+	}
+	cfg := d.hostConfigLocked(req.URL)
+	if req.URL.User != nil || req.Header.Get("Authorization") != "" {
+		return
+	}
+	switch {
+	case cfg.Token != "":
+		req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	case cfg.Username != "":
+		req.SetBasicAuth(cfg.Username, cfg.Password)
+	default:
+		entry, ok := d.netrc[strings.ToLower(req.URL.Hostname())]
+		if !ok {
+			entry, ok = d.netrc["*"]
+		}
+		if ok && entry.login != "" {
+			req.SetBasicAuth(entry.login, entry.password)
+		}
+	}
+}
+
+// checkRedirect swaps the host settings when a redirect leaves the host,
+// so one host's headers and credentials are never sent to another. net/http
+// copies the first request's headers onto every redirect, so what is
+// removed is whatever that request carried for its host: the host's
+// settings and the headers of entries on it.
+func (d *Downloader) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after 10 redirects")
+	}
+	first := via[0]
+	if strings.EqualFold(first.URL.Host, req.URL.Host) {
+		return nil
+	}
+	d.optionsMu.RLock()
+	for _, headers := range d.hostHeadersLocked(first.URL) {
+		for key := range headers {
+			req.Header.Del(key)
+		}
+	}
+	for entryURL, headers := range d.headers {
+		if sameHost(entryURL, first.URL) {
+			for key := range headers {
+				req.Header.Del(key)
+			}
+		}
+	}
+	d.optionsMu.RUnlock()
+	req.Header.Del("Authorization")
+	d.authorize(req)
+	return nil
+}
+
+// proxyFor picks the proxy for req: its host's own, the downloader's, or
+// the one from the environment.
+func (d *Downloader) proxyFor(req *http.Request) (*url.URL, error) {
+	d.optionsMu.RLock()
+	cfg := d.hostConfigLocked(req.URL)
+	proxy := d.proxy
+	d.optionsMu.RUnlock()
+
+	switch {
+	case cfg.Proxy == "direct":
+		return nil, nil
+	case cfg.Proxy != "":
+		return parseProxy(cfg.Proxy)
+	case proxy != nil:
+		return proxy, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// stripPassword drops the password from a URL's user info, for anything
+// written to disk. The user name stays, so the URL is still recognisable.
+func stripPassword(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	u.User = url.User(u.User.Username())
+	return u.String()
+}
+
+// redactURL hides the password in a URL's user info, for logging.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckRedirectStripsFirstHostHeaders(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 127.0.0.1 and localhost are different hosts to the client.
+		other := "http://" + strings.Replace(r.Host, "127.0.0.1", "localhost", 1)
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		case "/one":
+			http.Redirect(w, r, other+"/final", http.StatusFound)
+		case "/two":
+			http.Redirect(w, r, other+"/hop", http.StatusFound)
+		case "/hop":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		case "/final":
+			got = r.Header.Clone()
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path string
+		kept bool
+	}{
+		{"/same", true},
+		{"/one", false},
+		{"/two", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			d := NewDownloader(1, 0)
+			host := strings.TrimPrefix(server.URL, "http://")
+			d.SetHostConfig(host, HostConfig{Token: "host-token", Headers: map[string]string{"X-Api-Key": "host-key"}})
+			url := server.URL + tt.path
+			if err := d.Apply(Entry{URL: url, Headers: map[string]string{"X-Entry-Token": "entry-token"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			got = nil
+			req, err := d.newRequest(context.Background(), http.MethodGet, url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := d.client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got == nil {
+				t.Fatal("redirects never reached /final")
+			}
+
+			for _, key := range []string{"X-Entry-Token", "X-Api-Key", "Authorization"} {
+				if sent := got.Get(key) != ""; sent != tt.kept {
+					t.Errorf("%s sent = %v, want %v", key, sent, tt.kept)
+				}
+			}
+		})
+	}
+}
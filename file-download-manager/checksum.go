@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+type MismatchPolicy int
+
+const (
+	// MismatchDelete removes a file whose digest doesn't match.
+	MismatchDelete MismatchPolicy = iota
+	// MismatchQuarantine moves it into a quarantine directory for inspection.
+	MismatchQuarantine
+)
+
+// Checksum is an expected digest for a downloaded file.
+type Checksum struct {
+	Algorithm string // "sha256", "sha1" or "md5"
+	Digest    []byte
+}
+
+// ParseChecksum accepts "algo:hex" or bare hex, in which case the algorithm
+// is inferred from the digest length.
+func ParseChecksum(spec string) (Checksum, error) {
+	algorithm, digest, ok := strings.Cut(strings.TrimSpace(spec), ":")
+	if !ok {
+		digest, algorithm = algorithm, ""
+	}
+	algorithm = strings.ToLower(strings.ReplaceAll(algorithm, "-", ""))
+
+	sum, err := hex.DecodeString(digest)
+	if err != nil {
+		return Checksum{}, fmt.Errorf("invalid checksum %q: %s", spec, err)
+	}
+	if algorithm == "" {
+		switch len(sum) {
+		case sha256.Size:
+			algorithm = "sha256"
+		case sha1.Size:
+			algorithm = "sha1"
+		case md5.Size:
+			algorithm = "md5"
+		default:
+			return Checksum{}, fmt.Errorf("can't infer algorithm for %d byte checksum %q", len(sum), spec)
+		}
+	}
+
+	c := Checksum{Algorithm: algorithm, Digest: sum}
+	h, err := c.newHash()
+	if err != nil {
+		return Checksum{}, err
+	}
+	if len(sum) != h.Size() {
+		return Checksum{}, fmt.Errorf("%s checksum must be %d bytes, got %d", algorithm, h.Size(), len(sum))
+	}
+	return c, nil
+}
+
+func (c Checksum) newHash() (hash.Hash, error) {
+	switch c.Algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "md5":
+		return md5.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", c.Algorithm)
+	}
+}
+
+func (c Checksum) String() string {
+	return c.Algorithm + ":" + hex.EncodeToString(c.Digest)
+}
+
+// check compares a computed digest with the expected one.
+func (c Checksum) check(sum []byte) error {
+	if bytes.Equal(sum, c.Digest) {
+		return nil
+	}
+	return fmt.Errorf("expected %s, got %s: %w", c, hex.EncodeToString(sum), ErrChecksumMismatch)
+}
+
+// SetMismatchPolicy chooses what happens to files that fail verification.
+func (d *Downloader) SetMismatchPolicy(policy MismatchPolicy) {
+	d.mismatchPolicy = policy
+}
+
+// ExpectChecksum records the digest url's content must have, e.g.
+// "sha256:9f86d0...".
+func (d *Downloader) ExpectChecksum(url, spec string) error {
+	sum, err := ParseChecksum(spec)
+	if err != nil {
+		return err
+	}
+	d.optionsMu.Lock()
+	d.checksums[url] = sum
+	d.optionsMu.Unlock()
+	return nil
+}
+
+// LoadChecksumFile reads a SHA256SUMS-style file ("<hex>  <name>" or
+// "<hex> *<name>" per line). Entries apply to downloads by file name; the
+// algorithm comes from the digest length.
+func (d *Downloader) LoadChecksumFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to open checksum file %s: %s", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return fmt.Errorf("%s:%d: expected \"<digest> <file name>\"", path, line)
+		}
+		sum, err := ParseChecksum(fields[0])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", path, line, err)
+		}
+		name := strings.TrimPrefix(strings.Join(fields[1:], " "), "*")
+		d.optionsMu.Lock()
+		d.checksumsByName[filepath.Base(name)] = sum
+		d.optionsMu.Unlock()
+	}
+	return scanner.Err()
+}
+
+// expectedChecksum finds the digest for a download, by URL first and then
+// by its name. That is the name before any collision renaming, so saving
+// a.txt as a-1.txt can't slip past the SUMS entry for a.txt.
+func (d *Downloader) expectedChecksum(url, filePath string) *Checksum {
+	name := d.requestedName(filePath)
+	d.optionsMu.RLock()
+	defer d.optionsMu.RUnlock()
+	if sum, ok := d.checksums[url]; ok {
+		return &sum
+	}
+	if sum, ok := d.checksumsByName[name]; ok {
+		return &sum
+	}
+	return nil
+}
+
+// hashFile computes the digest of a file already on disk, for downloads
+// whose bytes didn't arrive in order.
+func hashFile(path string, sum Checksum) ([]byte, error) {
+	h, err := sum.newHash()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := copyBody(h, file); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// hashPrefix feeds the first n bytes of path into h.
+func hashPrefix(h hash.Hash, path string, n int64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	copied, err := copyBody(h, io.LimitReader(file, n))
+	if err == nil && copied != n {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// verifyOnDisk checks a finished file at tempPath against the digest
+// expected for url, rejecting it on mismatch.
+func (d *Downloader) verifyOnDisk(url, tempPath, filePath string) error {
+	expected := d.expectedChecksum(url, filePath)
+	if expected == nil {
+		return nil
+	}
+	sum, err := hashFile(tempPath, *expected)
+	if err == nil {
+		err = expected.check(sum)
+	}
+	if err != nil {
+		d.rejectFile(tempPath, filePath)
+		return fmt.Errorf("verifying %s: %w", redactURL(url), err)
+	}
+	return nil
+}
+
+// rejectFile disposes of a download that failed verification according to
+// the mismatch policy.
+func (d *Downloader) rejectFile(tempPath, filePath string) {
+	if d.mismatchPolicy != MismatchQuarantine {
+		os.Remove(tempPath)
+		return
+	}
+
+	dir := filepath.Join(filepath.Dir(filePath), "quarantine")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		os.Remove(tempPath)
+		return
+	}
+	target := filepath.Join(dir, filepath.Base(filePath))
+	if err := os.Rename(tempPath, target); err != nil {
+		os.Remove(tempPath)
+		return
+	}
+	d.logf("Quarantined %s\n", target)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseChecksum(t *testing.T) {
+	sha := sha256.Sum256([]byte("hello"))
+	tests := []struct {
+		spec      string
+		algorithm string
+		ok        bool
+	}{
+		{hex.EncodeToString(sha[:]), "sha256", true},
+		{"SHA-256:" + hex.EncodeToString(sha[:]), "sha256", true},
+		{"aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d", "sha1", true},
+		{"5d41402abc4b2a76b9719d911017c592", "md5", true},
+		{"abcd", "", false},
+		{"sha256:not-hex", "", false},
+	}
+	for _, tt := range tests {
+		sum, err := ParseChecksum(tt.spec)
+		if (err == nil) != tt.ok || sum.Algorithm != tt.algorithm {
+			t.Errorf("ParseChecksum(%q) = %s, %v; want algorithm %q, ok %v", tt.spec, sum.Algorithm, err, tt.algorithm, tt.ok)
+		}
+	}
+}
+
+// A download renamed to avoid an existing file is still checked against
+// the SUMS entry for the name it asked for.
+func TestChecksumFileMatchesRenamedDownload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	good := sha256.Sum256([]byte("hello"))
+	bad := sha256.Sum256([]byte("tampered"))
+	tests := []struct {
+		name string
+		sum  []byte
+		err  error
+	}{
+		{"match", good[:], nil},
+		{"mismatch", bad[:], ErrChecksumMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("older"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			sums := filepath.Join(t.TempDir(), "SHA256SUMS")
+			if err := os.WriteFile(sums, []byte(hex.EncodeToString(tt.sum)+"  file.txt\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			d := NewDownloader(1, 0)
+			d.logf = t.Logf
+			d.SetOutputDir(dir)
+			if err := d.LoadChecksumFile(sums); err != nil {
+				t.Fatal(err)
+			}
+			err := d.Download(context.Background(), server.URL+"/file.txt")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if data, _ := os.ReadFile(filepath.Join(dir, "file.txt")); string(data) != "older" {
+				t.Errorf("existing file.txt overwritten with %q", data)
+			}
+		})
+	}
+}
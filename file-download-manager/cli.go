@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cliOptions is everything main takes from the command line.
+type cliOptions struct {
+	dir            string
+	input          string
+	queuePath      string
+	concurrency    int
+	segments       int
+	retries        int
+	timeout        time.Duration
+	connectTimeout time.Duration
+	stallTimeout   time.Duration
+	mirrorOrder    MirrorOrder
+	limit          int64
+	collision      CollisionPolicy
+	checksumFile   string
+	quarantine     bool
+	update         bool
+	reportPath     string
+	extract        bool
+	extractDir     string
+	extractMax     int64
+	deleteArchives bool
+	recursive      bool
+	crawl          CrawlOptions
+	hostsFile      string
+	netrc          string
+	proxy          string
+	headers        map[string]string
+	urls           []string
+}
+
+func parseOptions(args []string, output io.Writer) (*cliOptions, error) {
+	opts := &cliOptions{}
+	flags := flag.NewFlagSet("file-download-manager", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.Usage = func() {
+		fmt.Fprintf(output, "usage: file-download-manager [flags] [url ...]\n\n")
+		fmt.Fprintf(output, "Downloads the given URLs, those listed in -input, and anything left in the queue from an earlier run.\n\n")
+		flags.PrintDefaults()
+	}
+
+	var limit, collision, mirrorOrder, extractMax, accept, reject, acceptRegexp string
+	flags.StringVar(&opts.dir, "dir", "downloads", "directory to save downloads in")
+	flags.StringVar(&opts.input, "input", "", "manifest of URLs: plain text, one per line, or JSON entries with url, mirrors, output, checksum and headers")
+	flags.StringVar(&opts.queuePath, "queue", "", "queue file (default <dir>/queue.json)")
+	flags.IntVar(&opts.concurrency, "concurrency", 2, "downloads to run at once")
+	flags.IntVar(&opts.segments, "segments", 4, "parallel range requests per large file; 1 disables")
+	flags.IntVar(&opts.retries, "retries", DefaultRetryPolicy.Attempts, "retries after a transient failure")
+	flags.DurationVar(&opts.timeout, "timeout", 0, "limit on each request, including the body; 0 for none")
+	flags.DurationVar(&opts.connectTimeout, "connect-timeout", 30*time.Second, "limit on connecting to a server")
+	flags.DurationVar(&opts.stallTimeout, "stall-timeout", defaultStallTimeout, "give up on a server, or switch mirrors, after this long without data; 0 to wait forever")
+	flags.StringVar(&mirrorOrder, "mirror-order", "listed", "which mirror to try first: listed, or fastest as measured")
+	flags.StringVar(&limit, "limit", "", "total bandwidth limit, e.g. 500K or 2M bytes per second")
+	flags.StringVar(&collision, "collision", "rename", "when a file exists: rename, skip or overwrite")
+	flags.StringVar(&opts.checksumFile, "checksums", "", "SHA256SUMS-style file to verify downloads against")
+	flags.BoolVar(&opts.quarantine, "quarantine", false, "keep files that fail verification in <dir>/quarantine")
+	flags.BoolVar(&opts.update, "update", false, "only fetch files that changed since they were last downloaded")
+	flags.BoolVar(&opts.extract, "extract", false, "extract .zip, .tar, .tar.gz and .tgz downloads")
+	flags.StringVar(&opts.extractDir, "extract-dir", "", "extract archives here (default a directory next to each archive)")
+	flags.StringVar(&extractMax, "extract-max-size", "4G", "refuse to extract more than this many bytes from one archive")
+	flags.BoolVar(&opts.deleteArchives, "delete-archives", false, "delete archives once extracted")
+	flags.BoolVar(&opts.recursive, "recursive", false, "treat the URLs as directory listings and download the files they link to")
+	flags.IntVar(&opts.crawl.MaxDepth, "depth", 5, "with -recursive, how many levels of subdirectories to follow")
+	flags.StringVar(&accept, "accept", "", "with -recursive, comma-separated globs of files to take, e.g. '*.tar.gz,docs/*.pdf'")
+	flags.StringVar(&reject, "reject", "", "with -recursive, comma-separated globs of files to leave out")
+	flags.StringVar(&acceptRegexp, "accept-regex", "", "with -recursive, take files whose path matches this regular expression")
+	flags.StringVar(&opts.hostsFile, "hosts", "", "JSON file of per-host settings: username, password, token, headers and proxy")
+	flags.StringVar(&opts.netrc, "netrc", defaultNetrcPath(), "netrc file with credentials for hosts not in -hosts; empty to ignore")
+	flags.StringVar(&opts.proxy, "proxy", "", "proxy for every host, e.g. http://proxy:3128 (default from HTTP_PROXY and HTTPS_PROXY)")
+	flags.Func("header", "add a header to every request, as 'Name: value'; repeatable", func(s string) error {
+		name, value, ok := strings.Cut(s, ":")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			return fmt.Errorf("want 'Name: value'")
+		}
+		if opts.headers == nil {
+			opts.headers = make(map[string]string)
+		}
+		opts.headers[name] = strings.TrimSpace(value)
+		return nil
+	})
+	flags.StringVar(&opts.reportPath, "report", "", "also write the summary report as JSON to this file")
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	opts.urls = flags.Args()
+
+	if opts.concurrency < 1 || opts.segments < 1 || opts.retries < 0 {
+		return nil, fmt.Errorf("-concurrency and -segments must be at least 1, -retries at least 0")
+	}
+	if opts.queuePath == "" {
+		opts.queuePath = filepath.Join(opts.dir, "queue.json")
+	}
+
+	var err error
+	if opts.limit, err = parseByteRate(limit); err != nil {
+		return nil, err
+	}
+	if opts.extractMax, err = parseByteRate(extractMax); err != nil {
+		return nil, err
+	}
+	opts.crawl.Accept = splitList(accept)
+	opts.crawl.Reject = splitList(reject)
+	if acceptRegexp != "" {
+		if opts.crawl.AcceptRegexp, err = regexp.Compile(acceptRegexp); err != nil {
+			return nil, fmt.Errorf("invalid -accept-regex: %s", err)
+		}
+	}
+	switch collision {
+	case "rename":
+		opts.collision = CollisionRename
+	case "skip":
+		opts.collision = CollisionSkip
+	case "overwrite":
+		opts.collision = CollisionOverwrite
+	default:
+		return nil, fmt.Errorf("unknown -collision %q: want rename, skip or overwrite", collision)
+	}
+	switch mirrorOrder {
+	case "listed":
+		opts.mirrorOrder = MirrorsInOrder
+	case "fastest":
+		opts.mirrorOrder = MirrorsFastest
+	default:
+		return nil, fmt.Errorf("unknown -mirror-order %q: want listed or fastest", mirrorOrder)
+	}
+	return opts, nil
+}
+
+// entries gathers the downloads named on the command line and in the
+// manifest. With -recursive those are index pages, and the files found
+// below them are returned instead.
+func (opts *cliOptions) entries(ctx context.Context, d *Downloader) ([]Entry, error) {
+	var entries []Entry
+	for _, url := range opts.urls {
+		entries = append(entries, Entry{URL: url})
+	}
+	if opts.input != "" {
+		listed, err := LoadManifest(opts.input)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, listed...)
+	}
+	if !opts.recursive {
+		return entries, nil
+	}
+
+	var files []Entry
+	for _, index := range entries {
+		// Index pages need the same headers as the files below them.
+		if err := d.Apply(Entry{URL: index.URL, Headers: index.Headers}); err != nil {
+			return nil, err
+		}
+		found, err := d.Crawl(ctx, index.URL, opts.crawl)
+		if err != nil {
+			return nil, err
+		}
+		d.logf("Found %d files under %s\n", len(found), redactURL(index.URL))
+		for _, file := range found {
+			// Settings on the index entry apply to everything below it.
+			file.Headers, file.Priority = index.Headers, index.Priority
+			if index.Output != "" {
+				file.Output = path.Join(index.Output, file.Output)
+			}
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// configure applies the options to a new Downloader.
+func (opts *cliOptions) configure(d *Downloader) error {
+	d.SetOutputDir(opts.dir)
+	d.SetTimeouts(opts.connectTimeout, opts.timeout)
+	d.SetGlobalLimit(opts.limit)
+	d.SetCollisionPolicy(opts.collision)
+	d.SetUpdateMode(opts.update)
+	d.SetStallTimeout(opts.stallTimeout)
+	d.SetMirrorOrder(opts.mirrorOrder)
+
+	retry := DefaultRetryPolicy
+	retry.Attempts = opts.retries
+	d.SetRetryPolicy(retry)
+
+	if opts.extract {
+		d.SetExtract(&ExtractOptions{
+			Dir:           opts.extractDir,
+			MaxBytes:      opts.extractMax,
+			DeleteArchive: opts.deleteArchives,
+		})
+	}
+	if opts.quarantine {
+		d.SetMismatchPolicy(MismatchQuarantine)
+	}
+	if err := opts.configureAuth(d); err != nil {
+		return err
+	}
+	if opts.checksumFile != "" {
+		return d.LoadChecksumFile(opts.checksumFile)
+	}
+	return nil
+}
+
+// configureAuth loads host settings, credentials and the proxy.
+func (opts *cliOptions) configureAuth(d *Downloader) error {
+	if opts.hostsFile != "" {
+		if err := d.LoadHostConfigs(opts.hostsFile); err != nil {
+			return err
+		}
+	}
+	if len(opts.headers) > 0 {
+		// -header goes to every host, alongside any headers set for "*".
+		all := d.HostConfig("*")
+		headers := make(map[string]string, len(all.Headers)+len(opts.headers))
+		for name, value := range all.Headers {
+			headers[name] = value
+		}
+		for name, value := range opts.headers {
+			headers[name] = value
+		}
+		all.Headers = headers
+		if err := d.SetHostConfig("*", all); err != nil {
+			return err
+		}
+	}
+	if opts.netrc != "" {
+		err := d.LoadNetrc(opts.netrc)
+		// A missing netrc only matters if it was asked for.
+		if _, statErr := os.Stat(opts.netrc); err != nil && !(os.IsNotExist(statErr) && opts.netrc == defaultNetrcPath()) {
+			return err
+		}
+	}
+	return d.SetProxy(opts.proxy)
+}
+
+// parseByteRate reads a byte count (or bytes per second) with an optional
+// K, M or G suffix.
+func parseByteRate(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
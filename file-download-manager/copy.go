@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+const copyBufferSize = 64 * 1024
+
+// copyBuffers bounds the memory used per active transfer to one buffer,
+// however large the file is.
+var copyBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, copyBufferSize)
+		return &buf
+	},
+}
+
+// copyBody streams src into dst through a pooled fixed-size buffer. Every
+// download writes through here, so it is the one place that sees each byte
+// on its way to disk.
+func copyBody(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	// Hiding any ReadFrom/WriteTo methods keeps io.CopyBuffer on our buffer.
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+}
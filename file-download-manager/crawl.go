@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const maxIndexSize = 8 << 20
+
+// hrefPattern finds link targets. Directory listings are simple enough that
+// a full HTML parser isn't needed.
+var hrefPattern = regexp.MustCompile(`(?i)<a\s[^>]*?href\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+
+// CrawlOptions selects which files a recursive download picks up from an
+// index page and the pages below it.
+type CrawlOptions struct {
+	// MaxDepth is how many levels of subdirectories to descend into; 0
+	// takes only the files linked from the starting page.
+	MaxDepth int
+	// Accept and Reject are glob patterns. Patterns containing a slash
+	// match the path below the starting page, others just the file name.
+	// With no Accept patterns (and no AcceptRegexp) every file is taken.
+	Accept       []string
+	Reject       []string
+	AcceptRegexp *regexp.Regexp
+}
+
+// Crawl walks the directory listing at root and returns an entry for every
+// matching file, with Output set so the remote directory structure is
+// recreated under the output directory. Only links below root are followed.
+func (d *Downloader) Crawl(ctx context.Context, root string, opts CrawlOptions) ([]Entry, error) {
+	base, err := url.Parse(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %s: %s", redactURL(root), err)
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+
+	var entries []Entry
+	seen := map[string]bool{base.String(): true}
+	pages := []*url.URL{base}
+	for depth := 0; len(pages) > 0 && depth <= opts.MaxDepth; depth++ {
+		var next []*url.URL
+		for _, page := range pages {
+			links, err := d.indexLinks(ctx, root, page)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				d.logf("Skipping index %s: %s\n", redactURL(page.String()), err)
+				continue
+			}
+			for _, link := range links {
+				rel, ok := below(base, link)
+				if !ok || seen[link.String()] {
+					continue
+				}
+				seen[link.String()] = true
+
+				if strings.HasSuffix(link.Path, "/") {
+					next = append(next, link)
+				} else if opts.matches(rel) {
+					entries = append(entries, Entry{URL: link.String(), Output: crawlOutputPath(rel)})
+				}
+			}
+		}
+		pages = next
+	}
+	return entries, nil
+}
+
+// indexLinks fetches an index page, with the settings configured for root,
+// and returns the absolute URLs it links to, without fragments.
+func (d *Downloader) indexLinks(ctx context.Context, root string, page *url.URL) ([]*url.URL, error) {
+	source := page.String()
+	fetcher, err := d.fetcherFor(source)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fetcher.Fetch(ctx, FetchRequest{URL: root, Source: source})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxIndexSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var links []*url.URL
+	for _, match := range hrefPattern.FindAllStringSubmatch(string(body), -1) {
+		href := html.UnescapeString(match[1] + match[2] + match[3])
+		ref, err := url.Parse(strings.TrimSpace(href))
+		if err != nil {
+			continue
+		}
+		link := page.ResolveReference(ref)
+		link.Fragment = ""
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+// below returns link's path relative to base, if link lies under it. Links
+// with a query string are skipped; in listings they only re-sort the page.
+func below(base, link *url.URL) (string, bool) {
+	if link.Scheme != base.Scheme || link.Host != base.Host || link.RawQuery != "" {
+		return "", false
+	}
+	rel, ok := strings.CutPrefix(link.Path, base.Path)
+	if !ok || rel == "" {
+		return "", false
+	}
+	return rel, true
+}
+
+func (opts CrawlOptions) matches(rel string) bool {
+	for _, pattern := range opts.Reject {
+		if globMatch(pattern, rel) {
+			return false
+		}
+	}
+	if len(opts.Accept) == 0 && opts.AcceptRegexp == nil {
+		return true
+	}
+	for _, pattern := range opts.Accept {
+		if globMatch(pattern, rel) {
+			return true
+		}
+	}
+	return opts.AcceptRegexp != nil && opts.AcceptRegexp.MatchString(rel)
+}
+
+func globMatch(pattern, rel string) bool {
+	target := rel
+	if !strings.Contains(pattern, "/") {
+		target = path.Base(rel)
+	}
+	ok, _ := path.Match(pattern, target)
+	return ok
+}
+
+// crawlOutputPath turns a remote relative path into a safe local one,
+// sanitizing every segment so nothing can climb out of the output directory.
+func crawlOutputPath(rel string) string {
+	segments := strings.Split(strings.Trim(rel, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = sanitizeName(segment)
+	}
+	return path.Join(segments...)
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrArchiveTooLarge = errors.New("archive exceeds extraction limits")
+
+// ExtractOptions turns on extracting .zip, .tar, .tar.gz and .tgz downloads.
+type ExtractOptions struct {
+	// Dir is where archives are extracted. Empty means a directory next to
+	// each archive, named after it without the extension.
+	Dir string
+	// MaxBytes and MaxFiles guard against archive bombs; zero picks the
+	// defaults below.
+	MaxBytes      int64
+	MaxFiles      int
+	DeleteArchive bool
+}
+
+const (
+	defaultExtractMaxBytes = 4 << 30
+	defaultExtractMaxFiles = 100000
+)
+
+// SetExtract enables extracting archives after download; nil disables it.
+func (d *Downloader) SetExtract(opts *ExtractOptions) {
+	d.extract = opts
+}
+
+// archiveBase returns the name without its archive extension, or "" if
+// path isn't an archive we can extract.
+func archiveBase(path string) string {
+	name := filepath.Base(path)
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) && len(name) > len(ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return ""
+}
+
+// extractDownload extracts filePath if it is an archive and extraction is
+// on, returning where the download's content now lives.
+func (d *Downloader) extractDownload(filePath string) (string, error) {
+	opts := d.extract
+	base := archiveBase(filePath)
+	if opts == nil || base == "" {
+		return filePath, nil
+	}
+
+	dest := opts.Dir
+	if dest == "" {
+		dest = filepath.Join(filepath.Dir(filePath), base)
+	}
+	x := &extractor{dest: dest, bytesLeft: opts.MaxBytes, filesLeft: opts.MaxFiles}
+	if x.bytesLeft <= 0 {
+		x.bytesLeft = defaultExtractMaxBytes
+	}
+	if x.filesLeft <= 0 {
+		x.filesLeft = defaultExtractMaxFiles
+	}
+
+	if err := x.extract(filePath); err != nil {
+		x.rollback()
+		return filePath, fmt.Errorf("extracting %s: %w", filePath, err)
+	}
+	d.logf("Extracted %s into %s\n", filePath, dest)
+
+	if !opts.DeleteArchive {
+		return filePath, nil
+	}
+	if err := os.Remove(filePath); err != nil {
+		return filePath, fmt.Errorf("unable to delete archive %s: %s", filePath, err)
+	}
+	return dest, nil
+}
+
+// extractor writes archive entries under dest, refusing anything that would
+// land outside it and stopping once the limits are used up.
+type extractor struct {
+	dest      string
+	bytesLeft int64
+	filesLeft int
+	created   []string // in creation order, for rollback
+
+	destCreated bool
+}
+
+func (x *extractor) extract(archivePath string) error {
+	if _, err := os.Stat(x.dest); os.IsNotExist(err) {
+		x.destCreated = true
+	}
+	if err := os.MkdirAll(x.dest, 0755); err != nil {
+		return err
+	}
+	if strings.HasSuffix(strings.ToLower(archivePath), ".zip") {
+		return x.extractZip(archivePath)
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if !strings.HasSuffix(strings.ToLower(archivePath), ".tar") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return x.extractTar(tar.NewReader(r))
+}
+
+func (x *extractor) extractZip(archivePath string) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	// The sizes in the directory can lie, so writeFile enforces the limit
+	// again while copying; this just fails obvious bombs early.
+	var declared uint64
+	for _, f := range zr.File {
+		declared += f.UncompressedSize64
+	}
+	if declared > uint64(x.bytesLeft) || len(zr.File) > x.filesLeft {
+		return ErrArchiveTooLarge
+	}
+
+	for _, f := range zr.File {
+		target, err := x.target(f.Name)
+		if err != nil {
+			return err
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = x.mkdir(target)
+		case mode.IsRegular():
+			var rc io.ReadCloser
+			if rc, err = f.Open(); err == nil {
+				err = x.writeFile(target, rc, mode)
+				rc.Close()
+			}
+		default:
+			// Symlinks and other special files are skipped in zips.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) extractTar(tr *tar.Reader) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := x.target(header.Name)
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = x.mkdir(target)
+		case tar.TypeReg:
+			err = x.writeFile(target, tr, header.FileInfo().Mode())
+		case tar.TypeSymlink:
+			err = x.symlink(target, header.Linkname)
+		default:
+			// Hard links, devices and the like aren't worth the risk.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// target resolves an entry name under dest, rejecting absolute paths and
+// any ".." that would climb out of it (zip-slip).
+func (x *extractor) target(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("archive entry %q has an absolute path", name)
+	}
+	target := filepath.Join(x.dest, filepath.FromSlash(name))
+	if !x.inside(target) {
+		return "", fmt.Errorf("archive entry %q escapes the extraction directory", name)
+	}
+	// A symlinked parent could lead anywhere, whatever the name says.
+	for dir := filepath.Dir(target); dir != x.dest && x.inside(dir); dir = filepath.Dir(dir) {
+		if info, err := os.Lstat(dir); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("archive entry %q goes through a symlink", name)
+		}
+	}
+	return target, nil
+}
+
+func (x *extractor) inside(path string) bool {
+	rel, err := filepath.Rel(x.dest, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (x *extractor) mkdir(target string) error {
+	var missing []string
+	for dir := target; x.inside(dir) && dir != x.dest; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		missing = append(missing, dir)
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		x.created = append(x.created, missing[i])
+	}
+	return nil
+}
+
+func (x *extractor) writeFile(target string, r io.Reader, mode os.FileMode) error {
+	if x.filesLeft--; x.filesLeft < 0 {
+		return ErrArchiveTooLarge
+	}
+	if err := x.mkdir(filepath.Dir(target)); err != nil {
+		return err
+	}
+	// Never write through a symlink, even one we created ourselves.
+	info, err := os.Lstat(target)
+	if err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("archive entry %s would overwrite a symlink", target)
+	}
+	existed := err == nil
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	if !existed {
+		x.created = append(x.created, target)
+	}
+	written, err := copyBody(file, io.LimitReader(r, x.bytesLeft+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if x.bytesLeft -= written; x.bytesLeft < 0 {
+		return ErrArchiveTooLarge
+	}
+	return nil
+}
+
+// symlink creates a link only if what it points to stays inside dest.
+// Checking the cleaned path isn't enough on its own: in "s/.." the ".."
+// applies to wherever s leads if s is a symlink, which may be extracted
+// before or after this link. So ".." may only lead the target, climbing
+// from the link's own directory, which is a real one.
+func (x *extractor) symlink(target, linkname string) error {
+	if filepath.IsAbs(linkname) || !x.inside(filepath.Join(filepath.Dir(target), linkname)) {
+		return fmt.Errorf("archive symlink %s -> %s points outside the extraction directory", target, linkname)
+	}
+	climbing := true
+	for _, part := range strings.Split(filepath.ToSlash(linkname), "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if !climbing {
+				return fmt.Errorf("archive symlink %s -> %s climbs out of a path that may go through a symlink", target, linkname)
+			}
+		default:
+			climbing = false
+		}
+	}
+	if x.filesLeft--; x.filesLeft < 0 {
+		return ErrArchiveTooLarge
+	}
+	if err := x.mkdir(filepath.Dir(target)); err != nil {
+		return err
+	}
+	if err := os.Symlink(linkname, target); err != nil {
+		return err
+	}
+	x.created = append(x.created, target)
+	return nil
+}
+
+// rollback removes what a failed extraction created, newest first, so no
+// half-extracted tree is left behind.
+func (x *extractor) rollback() {
+	for i := len(x.created) - 1; i >= 0; i-- {
+		os.Remove(x.created[i])
+	}
+	if x.destCreated {
+		os.Remove(x.dest)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type tarEntry struct {
+	name, link, body string
+	dir              bool
+}
+
+func writeTar(t *testing.T, path string, entries []tarEntry) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	tw := tar.NewWriter(file)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(entry.body))}
+		switch {
+		case entry.dir:
+			header.Typeflag, header.Mode, header.Size = tar.TypeDir, 0755, 0
+		case entry.link != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, entry.link, 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractRejectsEscapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		ok      bool
+	}{
+		{"plain file", []tarEntry{{name: "a/b.txt", body: "hi"}}, true},
+		{"link inside", []tarEntry{{name: "b.txt", body: "hi"}, {name: "a/", dir: true}, {name: "a/l", link: "../b.txt"}}, true},
+		{"parent name", []tarEntry{{name: "../evil.txt", body: "x"}}, false},
+		{"absolute name", []tarEntry{{name: "/tmp/evil.txt", body: "x"}}, false},
+		{"absolute link", []tarEntry{{name: "l", link: "/etc/passwd"}}, false},
+		{"link outside", []tarEntry{{name: "a/l", link: "../../evil"}}, false},
+		{"file through link", []tarEntry{{name: "sub/", dir: true}, {name: "d", link: "sub"}, {name: "d/f.txt", body: "x"}}, false},
+		{"dotdot after existing link", []tarEntry{{name: "a/b/", dir: true}, {name: "a/b/s", link: "../.."}, {name: "x", link: "a/b/s/.."}}, false},
+		{"dotdot before link exists", []tarEntry{{name: "a/b/", dir: true}, {name: "x", link: "a/b/s/.."}, {name: "a/b/s", link: "../.."}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "test.tar")
+			writeTar(t, archive, tt.entries)
+
+			d := NewDownloader(1, 0)
+			d.logf = t.Logf
+			dest := filepath.Join(dir, "out")
+			d.SetExtract(&ExtractOptions{Dir: dest})
+			_, err := d.extractDownload(archive)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v, want ok %v", err, tt.ok)
+			}
+			if !tt.ok {
+				if _, err := os.Lstat(dest); !os.IsNotExist(err) {
+					t.Errorf("failed extraction left %s behind", dest)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var errRangeNotSatisfiable = errors.New("requested range not satisfiable")
+
+// FetchRequest asks a Fetcher for the content of Source, the download's URL
+// or one of its mirrors.
+type FetchRequest struct {
+	URL    string // identifies the download; settings such as headers hang off it
+	Source string
+	Offset int64 // start here rather than at the beginning, if > 0
+	// IfRange is the validator the data before Offset came with. If the
+	// content no longer matches it, the whole file is sent instead.
+	IfRange string
+	// ETag and LastModified describe a copy we already have, letting Stat
+	// report NotModified.
+	ETag         string
+	LastModified string
+}
+
+// FetchResponse is an open stream of content.
+type FetchResponse struct {
+	Body   io.ReadCloser
+	Offset int64 // where Body starts: the requested offset, or 0 for the whole file
+	Total  int64 // full size of the content, -1 if unknown
+
+	// Validators identifying this version of the content, if the source
+	// has any. Without them a download can't be resumed.
+	ETag         string
+	LastModified string
+}
+
+// RemoteInfo is what a Fetcher can tell about content without fetching it.
+type RemoteInfo struct {
+	Name         string // suggested file name, if the source has one
+	ContentType  string
+	Size         int64 // -1 if unknown
+	ETag         string
+	LastModified string
+	NotModified  bool // matches the validators in the request
+}
+
+// Fetcher retrieves content for one URL scheme. Everything else about a
+// download (naming, progress, checksums, resuming) is shared.
+type Fetcher interface {
+	Stat(ctx context.Context, req FetchRequest) (*RemoteInfo, error)
+	Fetch(ctx context.Context, req FetchRequest) (*FetchResponse, error)
+}
+
+// RegisterFetcher makes the downloader use f for URLs with the given scheme.
+func (d *Downloader) RegisterFetcher(scheme string, f Fetcher) {
+	d.optionsMu.Lock()
+	defer d.optionsMu.Unlock()
+	d.fetchers[strings.ToLower(scheme)] = f
+}
+
+func (d *Downloader) fetcherFor(source string) (Fetcher, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %s: %s", redactURL(source), err)
+	}
+	d.optionsMu.RLock()
+	defer d.optionsMu.RUnlock()
+	f, ok := d.fetchers[strings.ToLower(u.Scheme)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported url scheme %q in %s", u.Scheme, redactURL(source))
+	}
+	return f, nil
+}
+
+// isHTTP reports whether source is fetched over HTTP, which is what
+// segmented downloads need.
+func isHTTP(source string) bool {
+	scheme, _, _ := strings.Cut(source, ":")
+	scheme = strings.ToLower(scheme)
+	return scheme == "http" || scheme == "https"
+}
+
+// httpFetcher fetches http and https URLs with the downloader's client and
+// the headers configured for each download.
+type httpFetcher struct {
+	d *Downloader
+}
+
+func (f *httpFetcher) Stat(ctx context.Context, req FetchRequest) (*RemoteInfo, error) {
+	httpReq, err := f.d.newSourceRequest(ctx, http.MethodHead, req.URL, req.Source)
+	if err != nil {
+		return nil, err
+	}
+	if req.ETag != "" {
+		httpReq.Header.Set("If-None-Match", req.ETag)
+	}
+	if req.LastModified != "" {
+		httpReq.Header.Set("If-Modified-Since", req.LastModified)
+	}
+
+	resp, err := f.d.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, newStatusError(req.Source, resp)
+	}
+	return &RemoteInfo{
+		Name:         nameFromDisposition(resp.Header.Get("Content-Disposition")),
+		ContentType:  resp.Header.Get("Content-Type"),
+		Size:         resp.ContentLength,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		NotModified:  resp.StatusCode == http.StatusNotModified,
+	}, nil
+}
+
+func (f *httpFetcher) Fetch(ctx context.Context, req FetchRequest) (*FetchResponse, error) {
+	httpReq, err := f.d.newSourceRequest(ctx, http.MethodGet, req.URL, req.Source)
+	if err != nil {
+		return nil, err
+	}
+	if req.Offset > 0 {
+		setResumeHeaders(httpReq, req.Offset, req.IfRange)
+	}
+
+	resp, err := f.d.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && req.Offset > 0:
+		start, _, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		return &FetchResponse{
+			Body:         resp.Body,
+			Offset:       start,
+			Total:        total,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		}, nil
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && req.Offset > 0:
+		resp.Body.Close()
+		// Either we already have every byte, or the file shrank.
+		if _, _, total, err := parseContentRange(resp.Header.Get("Content-Range")); err == nil && total == req.Offset {
+			return &FetchResponse{Body: http.NoBody, Offset: total, Total: total}, nil
+		}
+		return nil, errRangeNotSatisfiable
+	case resp.StatusCode == http.StatusOK:
+		// A full body: either a fresh download, or the validators changed
+		// or the server ignores ranges.
+		return &FetchResponse{
+			Body:         resp.Body,
+			Total:        resp.ContentLength,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		}, nil
+	default:
+		resp.Body.Close()
+		return nil, newStatusError(req.Source, resp)
+	}
+}
+
+// fileFetcher reads file:// URLs from the local filesystem, using the
+// modification time as the validator.
+type fileFetcher struct{}
+
+func localPath(source string) (string, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return "", err
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf("file url %s names a remote host", redactURL(source))
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+func (fileFetcher) Stat(ctx context.Context, req FetchRequest) (*RemoteInfo, error) {
+	path, err := localPath(req.Source)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+	modified := info.ModTime().UTC().Format(http.TimeFormat)
+	return &RemoteInfo{
+		Name:         filepath.Base(path),
+		ContentType:  mime.TypeByExtension(filepath.Ext(path)),
+		Size:         info.Size(),
+		LastModified: modified,
+		NotModified:  req.LastModified == modified,
+	}, nil
+}
+
+func (fileFetcher) Fetch(ctx context.Context, req FetchRequest) (*FetchResponse, error) {
+	path, err := localPath(req.Source)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err == nil && info.IsDir() {
+		err = fmt.Errorf("%s is a directory", path)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	resp := &FetchResponse{
+		Body:         file,
+		Total:        info.Size(),
+		LastModified: info.ModTime().UTC().Format(http.TimeFormat),
+	}
+	if req.Offset > 0 && (req.IfRange == "" || req.IfRange == resp.LastModified) {
+		if req.Offset > resp.Total {
+			file.Close()
+			return nil, errRangeNotSatisfiable
+		}
+		if _, err := file.Seek(req.Offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+		resp.Offset = req.Offset
+	}
+	return resp, nil
+}
+
+// dataFetcher serves the content embedded in data: URLs (RFC 2397).
+type dataFetcher struct{}
+
+// parseDataURL returns the media type and decoded content of a data: URL.
+func parseDataURL(source string) (string, []byte, error) {
+	rest, ok := strings.CutPrefix(source, "data:")
+	if !ok {
+		return "", nil, fmt.Errorf("not a data url")
+	}
+	header, payload, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", nil, fmt.Errorf("malformed data url: missing comma")
+	}
+
+	mediaType, isBase64 := strings.CutSuffix(header, ";base64")
+	if mediaType == "" || strings.HasPrefix(mediaType, ";") {
+		mediaType = "text/plain" + mediaType
+	}
+
+	if isBase64 {
+		data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+		if err != nil {
+			return "", nil, fmt.Errorf("malformed data url: %s", err)
+		}
+		return mediaType, data, nil
+	}
+	data, err := url.PathUnescape(payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("malformed data url: %s", err)
+	}
+	return mediaType, []byte(data), nil
+}
+
+func (dataFetcher) Stat(ctx context.Context, req FetchRequest) (*RemoteInfo, error) {
+	mediaType, data, err := parseDataURL(req.Source)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteInfo{Name: "data", ContentType: mediaType, Size: int64(len(data))}, nil
+}
+
+func (dataFetcher) Fetch(ctx context.Context, req FetchRequest) (*FetchResponse, error) {
+	_, data, err := parseDataURL(req.Source)
+	if err != nil {
+		return nil, err
+	}
+	if req.Offset > int64(len(data)) {
+		return nil, errRangeNotSatisfiable
+	}
+	return &FetchResponse{
+		Body:   io.NopCloser(bytes.NewReader(data[req.Offset:])),
+		Offset: req.Offset,
+		Total:  int64(len(data)),
+	}, nil
+}
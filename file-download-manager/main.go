@@ -1,85 +1,478 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"hash"
 	"io"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"strings"
+	"os/signal"
+	"path/filepath"
 	"sync"
 	"time"
 )
 
-func downloadFile(url string, wg *sync.WaitGroup) error {
+type Downloader struct {
+	client *http.Client
+	// segments is the number of concurrent range requests used for files of
+	// at least minSegmentSize bytes; 1 disables segmented downloads.
+	segments       int
+	minSegmentSize int64
+	progress       *ProgressTracker
+	// logf prints status messages; point it at TerminalRenderer.Logf so
+	// they don't tear the live progress lines.
+	logf func(format string, args ...interface{})
 
-	currentDir, err := os.Getwd()
+	outputDir string
+
+	// optionsMu guards the per-URL settings below, which the queue applies
+	// while other downloads are running.
+	optionsMu       sync.RWMutex
+	outputs         map[string]string      // by URL, from Entry.Output
+	headers         map[string]http.Header // by URL
+	hosts           map[string]HostConfig  // by host, host:port or "*"
+	netrc           map[string]netrcEntry  // by host name, "*" for default
+	proxy           *url.URL               // nil uses the environment
+	checksums       map[string]Checksum    // by URL
+	checksumsByName map[string]Checksum    // by saved file name, from SUMS files
+	mismatchPolicy  MismatchPolicy
+
+	globalLimit    *RateLimiter
+	limitMu        sync.Mutex
+	defaultLimit   int64
+	downloadLimits map[string]*RateLimiter // by URL
+	customLimits   map[string]bool         // URLs given their own limit
+
+	results *Results
+
+	retry RetryPolicy
+
+	mirrors      map[string][]string // by URL, alternatives to it
+	fetchers     map[string]Fetcher  // by URL scheme
+	mirrorOrder  MirrorOrder
+	stallTimeout time.Duration
+
+	extract *ExtractOptions
+
+	updateMode   bool
+	validatorsMu sync.Mutex
+
+	collisionPolicy CollisionPolicy
+	namesMu         sync.Mutex
+	claimed         map[string]string // paths held by running downloads, to the name each asked for
+}
+
+func NewDownloader(segments int, minSegmentSize int64) *Downloader {
+	if segments < 1 {
+		segments = 1
+	}
+	d := &Downloader{
+		segments:        segments,
+		minSegmentSize:  minSegmentSize,
+		progress:        NewProgressTracker(),
+		logf:            func(format string, args ...interface{}) { fmt.Printf(format, args...) },
+		outputDir:       "downloads",
+		outputs:         make(map[string]string),
+		headers:         make(map[string]http.Header),
+		hosts:           make(map[string]HostConfig),
+		checksums:       make(map[string]Checksum),
+		checksumsByName: make(map[string]Checksum),
+		globalLimit:     NewRateLimiter(0),
+		downloadLimits:  make(map[string]*RateLimiter),
+		customLimits:    make(map[string]bool),
+		results:         NewResults(),
+		claimed:         make(map[string]string),
+		retry:           DefaultRetryPolicy,
+		mirrors:         make(map[string][]string),
+		fetchers:        make(map[string]Fetcher),
+		stallTimeout:    defaultStallTimeout,
+	}
+	d.client = d.newClient(http.DefaultTransport.(*http.Transport).Clone(), 0)
+	d.RegisterFetcher("http", &httpFetcher{d})
+	d.RegisterFetcher("https", &httpFetcher{d})
+	d.RegisterFetcher("file", fileFetcher{})
+	d.RegisterFetcher("data", dataFetcher{})
+	return d
+}
+
+// Progress returns the tracker that follows every download this
+// Downloader runs.
+func (d *Downloader) Progress() *ProgressTracker {
+	return d.progress
+}
+
+// SetOutputDir changes where downloads are saved; relative paths are
+// resolved against the working directory.
+func (d *Downloader) SetOutputDir(dir string) {
+	d.outputDir = dir
+}
+
+// SetTimeouts limits how long connecting may take and how long a single
+// request may run in total. Zero means no limit.
+func (d *Downloader) SetTimeouts(connect, request time.Duration) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = connect
+	d.client = d.newClient(transport, request)
+}
+
+// newClient wraps transport with the per-host proxy and redirect handling.
+func (d *Downloader) newClient(transport *http.Transport, timeout time.Duration) *http.Client {
+	transport.Proxy = d.proxyFor
+	return &http.Client{Transport: transport, Timeout: timeout, CheckRedirect: d.checkRedirect}
+}
+
+// Results returns the outcome of every download this Downloader has run.
+func (d *Downloader) Results() *Results {
+	return d.results
+}
+
+// Download fetches url into the output directory, stopping early if ctx is
+// cancelled. A cancelled resumable download keeps its partial file, so
+// calling Download again later continues where it left off.
+func (d *Downloader) Download(ctx context.Context, url string) (err error) {
+	started := time.Now()
+	var filePath string
+	var status ResultStatus // set when nothing needed downloading
+	defer func() {
+		result := newResult(url, filePath, started, ctx.Err() != nil, err)
+		if status != "" {
+			result.Status = status
+		}
+		d.results.record(result)
+	}()
+
+	dirPath, err := filepath.Abs(d.outputDir)
 	if err != nil {
 		return fmt.Errorf("unable to get the current working dir with err %s", err)
 	}
 
-	defer wg.Done()
-	splitUrlSlice := strings.Split(url, "/")
-	fileName := splitUrlSlice[len(splitUrlSlice)-1]
-
-	dirPath := currentDir + "/downloads/"
-	filePath := dirPath + fileName
-	fmt.Println(filePath)
+	var name string
+	if output := d.outputFor(url); output != "" {
+		if !filepath.IsAbs(output) {
+			output = filepath.Join(dirPath, output)
+		}
+		dirPath, name = filepath.Dir(output), filepath.Base(output)
+	}
 	err = os.MkdirAll(dirPath, 0755)
 
 	if err != nil {
-		return fmt.Errorf("not able to create the dir /downloads with err %s", err)
+		return fmt.Errorf("not able to create the dir %s with err %s", dirPath, err)
+	}
+
+	if name == "" {
+		name = d.remoteName(ctx, url)
+	}
+	claimed := false
+	if d.updateMode {
+		if known, ok := d.existingDownload(dirPath, url, filepath.Join(dirPath, name)); ok {
+			// If the check itself fails, fetching the file again is the safe bet.
+			if unchanged, err := d.unchangedSince(ctx, url, known); err == nil && unchanged {
+				filePath, status = known.Path, ResultUnchanged
+				d.logf("Up to date: %s\n", filePath)
+				return nil
+			}
+			filePath, claimed = known.Path, d.claimExisting(known.Path, name)
+		}
 	}
+	if !claimed {
+		var ok bool
+		if filePath, ok = d.claimPath(dirPath, name); !ok {
+			status = ResultSkipped
+			d.logf("Skipping %s: %s already exists\n", redactURL(url), filePath)
+			return nil
+		}
+	}
+	defer d.releasePath(filePath)
+	d.logf("%s\n", filePath)
 
-	file, err := os.Create(filePath)
+	partPath := filePath + ".part"
+	sources := d.sources(ctx, url)
+	err = d.withRetries(ctx, url, func() error {
+		if d.segments > 1 {
+			return d.downloadSegmented(ctx, url, sources, filePath, partPath)
+		}
+		return d.fetchFromSources(ctx, url, sources, filePath, partPath)
+	})
+	d.progress.finish(url, err)
+	if err == nil {
+		filePath, err = d.extractDownload(filePath)
+	}
+	return err
+}
 
+// fetchToFile streams url to disk and renames the result to filePath once
+// complete. Resumable downloads go to partPath, which stays behind with its
+// metadata on failure so the next run can continue from it.
+func (d *Downloader) fetchToFile(ctx context.Context, url, source, filePath, partPath string) error {
+	fetcher, err := d.fetcherFor(source)
 	if err != nil {
-		return fmt.Errorf("not able to create the file with filepath %s with err %s", filePath, err)
+		return err
 	}
+	reqCtx, watch := d.watchStalls(ctx)
+	defer watch.stop()
 
-	defer file.Close()
+	req := FetchRequest{URL: url, Source: source}
+	meta, offset := loadPartial(partPath, url)
+	if meta != nil && meta.Segments != nil {
+		// A segmented partial has holes; it can't be resumed as one stream.
+		meta = nil
+	}
+	if meta != nil {
+		req.Offset = offset
+		// Validators mean nothing to another mirror.
+		if meta.from(source) {
+			req.IfRange = meta.validator()
+		}
+	}
 
-	resp, err := http.Get(url)
+	resp, err := fetcher.Fetch(reqCtx, req)
+	if errors.Is(err, errRangeNotSatisfiable) {
+		// The file shrank since the partial was saved; start over.
+		removePartial(partPath)
+		watch.stop()
+		return d.fetchToFile(ctx, url, source, filePath, partPath)
+	}
 	if err != nil {
-		return fmt.Errorf("invalid response with errr  %s", err)
+		return fmt.Errorf("invalid response with errr  %w", watch.check(err))
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if meta != nil && resp.Offset > 0 {
+		switched := !meta.from(source)
+		if resp.Offset != offset || (switched && meta.TotalSize > 0 && resp.Total != meta.TotalSize) {
+			// The source answered a different range than we asked for, or
+			// the mirror we switched to has a different file.
+			removePartial(partPath)
+			return fmt.Errorf("unexpected range starting at %d resuming %s from %s", resp.Offset, redactURL(url), redactURL(source))
+		}
+		if resp.Offset == resp.Total {
+			// Every byte was already on disk.
+			if err := d.verifyOnDisk(url, partPath, filePath); err != nil {
+				os.Remove(metaPath(partPath))
+				return err
+			}
+			os.Remove(metaPath(partPath))
+			if err := os.Rename(partPath, filePath); err != nil {
+				return err
+			}
+			d.finishFile(url, filePath, meta.ETag, meta.LastModified)
+			return nil
+		}
+		if switched {
+			// From now on, resume against this mirror's validators.
+			meta.Source = stripPassword(source)
+			if resp.ETag != "" || resp.LastModified != "" {
+				meta.ETag, meta.LastModified = resp.ETag, resp.LastModified
+			}
+		}
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		d.logf("Resuming %s at byte %d from %s\n", redactURL(url), offset, redactURL(source))
+	} else {
+		// The whole file: either a fresh download, or the old partial data
+		// is stale and discarded.
+		offset = 0
+		meta = &partialMeta{
+			URL:          stripPassword(url),
+			Source:       stripPassword(source),
+			ETag:         resp.ETag,
+			LastModified: resp.LastModified,
+			TotalSize:    resp.Total,
+		}
+	}
 
+	// Only downloads the server lets us validate can be resumed, so only
+	// those keep a .part file across runs; anything else streams into a
+	// unique temp file that is removed if the transfer fails.
+	resumable := meta.ETag != "" || meta.LastModified != ""
+	var file *os.File
+	if resumable {
+		file, err = os.OpenFile(partPath, flags, 0644)
+		if err == nil {
+			err = savePartial(partPath, meta)
+		}
+	} else {
+		removePartial(partPath)
+		file, err = createTemp(filePath)
+	}
 	if err != nil {
-		return fmt.Errorf("invalid body  %s", err)
+		if file != nil {
+			file.Close()
+		}
+		return fmt.Errorf("not able to create the file for %s with err %s", filePath, err)
 	}
-	fmt.Printf("File Downloaded from the url:%s\n", url)
 
-	err = os.WriteFile(filePath, body, 0644)
+	tracked := d.progress.start(url, offset, resp.Total)
 
+	// The digest is computed as bytes stream past; a resumed download
+	// first feeds it what is already on disk.
+	dst := tracked.writer(file)
+	expected := d.expectedChecksum(url, filePath)
+	var hasher hash.Hash
+	if expected != nil {
+		if hasher, err = expected.newHash(); err == nil && offset > 0 {
+			err = hashPrefix(hasher, partPath, offset)
+		}
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("unable to verify %s: %s", redactURL(url), err)
+		}
+		dst = io.MultiWriter(dst, hasher)
+	}
+
+	written, err := copyBody(dst, d.throttle(reqCtx, url, watch.reader(resp.Body)))
+	err = watch.check(err)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		return fmt.Errorf("failed to write file: %s", err)
+		if resumable {
+			meta.Received = offset + written
+			savePartial(partPath, meta)
+		} else {
+			os.Remove(file.Name())
+		}
+		return fmt.Errorf("download of %s failed after %d bytes: %w", redactURL(url), offset+written, err)
 	}
 
+	if expected != nil {
+		if err := expected.check(hasher.Sum(nil)); err != nil {
+			os.Remove(metaPath(partPath))
+			d.rejectFile(file.Name(), filePath)
+			return fmt.Errorf("verifying %s: %w", redactURL(url), err)
+		}
+	}
+
+	// Rename is atomic, so filePath is either absent or complete.
+	if err := os.Rename(file.Name(), filePath); err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to move %s into place: %s", file.Name(), err)
+	}
+	os.Remove(metaPath(partPath))
+	d.finishFile(url, filePath, meta.ETag, meta.LastModified)
+	d.logf("File Downloaded from the url:%s\n", redactURL(url))
 	return nil
+}
 
+// createTemp opens a new, uniquely named file next to filePath. Unlike
+// os.CreateTemp it uses mode 0644 (less the umask), the mode the download
+// keeps once renamed into place.
+func createTemp(filePath string) (*os.File, error) {
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("%s.%d.tmp", filePath, rand.Uint32())
+		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) && i < 10000 {
+			continue
+		}
+		return file, err
+	}
 }
+
 func main() {
+	opts, err := parseOptions(os.Args[1:], os.Stderr)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	var wg sync.WaitGroup
+	downloader := NewDownloader(opts.segments, 8<<20)
+	if err := opts.configure(downloader); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	start := time.Now()
+	// Ctrl-C pauses active downloads and saves the queue for the next run.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	entries, err := opts.entries(ctx, downloader)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	urls := []string{
-		"https://www.w3.org/TR/png/iso_8859-1.txt",
-		"https://www.w3.org/WAI/ER/tests/xhtml/testfiles/resources/pdf/dummy.pdf",
-		"https://jsonplaceholder.typicode.com/posts.json",
-		"https://www.youtube.com/shorts/Mv0_WQ9uBjY",
+	queue, err := OpenQueue(opts.queuePath, downloader, opts.concurrency)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 
-	for _, url := range urls {
-		wg.Add(1)
-		go downloadFile(url, &wg)
+	// A URL already in the queue from an earlier run reuses its item rather
+	// than being added twice: waiting ones keep their place, and finished
+	// or failed ones are queued again to be fetched anew. Items are matched
+	// without passwords, which the queue file leaves out.
+	known := make(map[string]QueueItem)
+	for _, item := range queue.List() {
+		if _, ok := known[stripPassword(item.URL)]; !ok {
+			known[stripPassword(item.URL)] = item
+		}
+	}
+	for _, entry := range entries {
+		if item, ok := known[stripPassword(entry.URL)]; ok {
+			if err := queue.Requeue(item.ID, entry); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			continue
+		}
+		id, err := queue.AddEntry(entry)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		known[stripPassword(entry.URL)] = QueueItem{ID: id, URL: entry.URL, State: JobQueued}
+	}
+	// Paused and failed items from earlier runs stay put until asked for.
+	waiting := 0
+	for _, item := range queue.List() {
+		if item.State == JobQueued || item.State == JobActive {
+			waiting++
+		}
 	}
+	if waiting == 0 {
+		fmt.Fprintln(os.Stderr, "nothing to download: pass URLs or -input")
+		os.Exit(2)
+	}
+
+	renderer := NewTerminalRenderer(os.Stdout)
+	downloader.logf = renderer.Logf
+	stopProgress := downloader.Progress().Watch(200*time.Millisecond, renderer.Render)
+
+	start := time.Now()
 
-	wg.Wait()
+	queue.Start(ctx)
+	queue.WaitIdle(ctx)
+	queue.Stop()
+	stopProgress()
 
 	duration := time.Since(start)
+	fmt.Println()
+	downloader.Results().WriteSummary(os.Stdout)
 	fmt.Println(duration)
+
+	if opts.reportPath != "" {
+		if err := downloader.Results().WriteJSON(opts.reportPath); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if downloader.Results().Failed() {
+		os.Exit(1)
+	}
+	if ctx.Err() != nil {
+		os.Exit(130)
+	}
 }
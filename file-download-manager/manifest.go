@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Entry is one download with its own settings. Output, if set, is the path
+// to save to, relative to the output directory unless absolute. Mirrors are
+// other URLs serving the same file; Headers are only sent to URL's host.
+type Entry struct {
+	URL      string            `json:"url"`
+	Mirrors  []string          `json:"mirrors,omitempty"`
+	Output   string            `json:"output,omitempty"`
+	Checksum string            `json:"checksum,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Priority int               `json:"priority,omitempty"`
+}
+
+// LoadManifest reads the downloads listed in path. A JSON manifest is an
+// array of entries, or an object with a "downloads" array; anything else is
+// read as plain text, one URL per line, with # comments.
+func LoadManifest(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest %s: %s", path, err)
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		return parseJSONManifest(path, trimmed)
+	}
+
+	var entries []Entry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, Entry{URL: line})
+	}
+	return entries, scanner.Err()
+}
+
+func parseJSONManifest(path string, data []byte) ([]Entry, error) {
+	var entries []Entry
+	if data[0] == '{' {
+		var wrapper struct {
+			Downloads []Entry `json:"downloads"`
+		}
+		if err := json.Unmarshal(data, &wrapper); err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %s", path, err)
+		}
+		entries = wrapper.Downloads
+	} else if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %s", path, err)
+	}
+
+	for i, entry := range entries {
+		if entry.URL == "" {
+			return nil, fmt.Errorf("invalid manifest %s: entry %d has no url", path, i+1)
+		}
+		if entry.Checksum != "" {
+			if _, err := ParseChecksum(entry.Checksum); err != nil {
+				return nil, fmt.Errorf("invalid manifest %s: entry %d: %s", path, i+1, err)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// Apply registers entry's settings for its URL, replacing any earlier ones.
+func (d *Downloader) Apply(entry Entry) error {
+	if entry.Checksum != "" {
+		if err := d.ExpectChecksum(entry.URL, entry.Checksum); err != nil {
+			return err
+		}
+	}
+
+	d.optionsMu.Lock()
+	defer d.optionsMu.Unlock()
+	if entry.Output != "" {
+		d.outputs[entry.URL] = entry.Output
+	} else {
+		delete(d.outputs, entry.URL)
+	}
+	if len(entry.Mirrors) > 0 {
+		d.mirrors[entry.URL] = entry.Mirrors
+	} else {
+		delete(d.mirrors, entry.URL)
+	}
+	if len(entry.Headers) > 0 {
+		header := make(http.Header, len(entry.Headers))
+		for key, value := range entry.Headers {
+			header.Set(key, value)
+		}
+		d.headers[entry.URL] = header
+	} else {
+		delete(d.headers, entry.URL)
+	}
+	return nil
+}
+
+// newRequest builds a request for url carrying the headers configured for it.
+func (d *Downloader) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	return d.newSourceRequest(ctx, method, url, url)
+}
+
+// newSourceRequest is newRequest for fetching url's content from source,
+// one of its mirrors. The entry's headers often carry credentials, so they
+// only go to mirrors on url's own host; others get just their host settings.
+func (d *Downloader) newSourceRequest(ctx context.Context, method, url, source string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	if sameHost(url, req.URL) {
+		d.optionsMu.RLock()
+		for key, values := range d.headers[url] {
+			req.Header[key] = values
+		}
+		d.optionsMu.RUnlock()
+	}
+	d.authorize(req)
+	return req, nil
+}
+
+// sameHost reports whether rawURL is served by the same scheme and host as u.
+func sameHost(rawURL string, u *url.URL) bool {
+	other, err := url.Parse(rawURL)
+	return err == nil && strings.EqualFold(other.Scheme, u.Scheme) && strings.EqualFold(other.Host, u.Host)
+}
+
+// outputFor returns the path configured for url, or "".
+func (d *Downloader) outputFor(url string) string {
+	d.optionsMu.RLock()
+	defer d.optionsMu.RUnlock()
+	return d.outputs[url]
+}
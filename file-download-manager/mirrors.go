@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MirrorOrder decides which mirror of a file is tried first.
+type MirrorOrder int
+
+const (
+	// MirrorsInOrder tries the primary URL, then the mirrors as listed.
+	MirrorsInOrder MirrorOrder = iota
+	// MirrorsFastest times a short sample from every mirror and tries the
+	// quickest first.
+	MirrorsFastest
+)
+
+const (
+	speedSampleSize    = 256 << 10
+	speedSampleTimeout = 10 * time.Second
+)
+
+// SetMirrorOrder chooses how mirrors are ranked.
+func (d *Downloader) SetMirrorOrder(order MirrorOrder) {
+	d.mirrorOrder = order
+}
+
+// sources lists every URL url's content can be fetched from, best first.
+func (d *Downloader) sources(ctx context.Context, url string) []string {
+	d.optionsMu.RLock()
+	sources := append([]string{url}, d.mirrors[url]...)
+	d.optionsMu.RUnlock()
+
+	if len(sources) == 1 || d.mirrorOrder != MirrorsFastest {
+		return sources
+	}
+
+	speeds := make([]float64, len(sources))
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source string) {
+			defer wg.Done()
+			speeds[i] = d.sampleSpeed(ctx, url, source)
+		}(i, source)
+	}
+	wg.Wait()
+
+	ranked := make([]int, len(sources))
+	for i := range ranked {
+		ranked[i] = i
+	}
+	sort.SliceStable(ranked, func(a, b int) bool {
+		return speeds[ranked[a]] > speeds[ranked[b]]
+	})
+	ordered := make([]string, len(sources))
+	shown := make([]string, len(sources))
+	for i, index := range ranked {
+		ordered[i] = sources[index]
+		shown[i] = redactURL(ordered[i])
+	}
+	d.logf("Mirror order for %s: %v\n", redactURL(url), shown)
+	return ordered
+}
+
+// sampleSpeed fetches the start of the file from source and returns the
+// bytes per second it managed, or 0 if the mirror didn't answer properly.
+func (d *Downloader) sampleSpeed(ctx context.Context, url, source string) float64 {
+	ctx, cancel := context.WithTimeout(ctx, speedSampleTimeout)
+	defer cancel()
+
+	fetcher, err := d.fetcherFor(source)
+	if err != nil {
+		return 0
+	}
+	start := time.Now()
+	resp, err := fetcher.Fetch(ctx, FetchRequest{URL: url, Source: source})
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+	n, _ := io.Copy(io.Discard, io.LimitReader(resp.Body, speedSampleSize))
+	elapsed := time.Since(start)
+	if n == 0 || elapsed <= 0 {
+		return 0
+	}
+	return float64(n) / elapsed.Seconds()
+}
+
+// fetchFromSources streams url from each source in turn until one delivers
+// the whole file. A resumable partial carries over, so a mirror that stalls
+// halfway hands over to the next without starting again.
+func (d *Downloader) fetchFromSources(ctx context.Context, url string, sources []string, filePath, partPath string) error {
+	var err error
+	for i, source := range sources {
+		if i > 0 {
+			d.logf("Switching %s to mirror %s: %s\n", redactURL(url), redactURL(source), err)
+		}
+		err = d.fetchToFile(ctx, url, source, filePath, partPath)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
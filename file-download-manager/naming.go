@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// CollisionPolicy decides what happens when a download's name is already
+// taken, either by a file on disk or by another running download.
+type CollisionPolicy int
+
+const (
+	// CollisionRename picks the first free "name-1.ext", "name-2.ext", ...
+	CollisionRename CollisionPolicy = iota
+	// CollisionSkip leaves the existing file alone and skips the download.
+	CollisionSkip
+	// CollisionOverwrite replaces the existing file. A name still being
+	// written by another download is renamed instead.
+	CollisionOverwrite
+)
+
+const maxNameLength = 200
+
+// preferredExtensions covers types whose first entry in the mime table is
+// not the one people expect.
+var preferredExtensions = map[string]string{
+	"text/html":              ".html",
+	"text/plain":             ".txt",
+	"application/json":       ".json",
+	"application/pdf":        ".pdf",
+	"application/zip":        ".zip",
+	"application/gzip":       ".gz",
+	"application/x-gzip":     ".gz",
+	"application/x-tar":      ".tar",
+	"image/jpeg":             ".jpg",
+	"image/png":              ".png",
+	"application/javascript": ".js",
+	"text/javascript":        ".js",
+	"text/css":               ".css",
+	"application/xml":        ".xml",
+	"text/xml":               ".xml",
+}
+
+// SetCollisionPolicy chooses what happens when a file name is already taken.
+func (d *Downloader) SetCollisionPolicy(policy CollisionPolicy) {
+	d.collisionPolicy = policy
+}
+
+// remoteName works out what to call rawURL on disk: the name its source
+// suggests (such as Content-Disposition) if any, else the last path
+// segment, with an extension added from the content type when the name
+// has none.
+func (d *Downloader) remoteName(ctx context.Context, rawURL string) string {
+	name := nameFromURL(rawURL)
+
+	fetcher, err := d.fetcherFor(rawURL)
+	if err != nil {
+		return sanitizeName(name)
+	}
+	info, err := fetcher.Stat(ctx, FetchRequest{URL: rawURL, Source: rawURL})
+	if err != nil {
+		// Plenty of servers refuse HEAD; the URL will have to do.
+		return sanitizeName(name)
+	}
+
+	if info.Name != "" {
+		name = info.Name
+	}
+	name = sanitizeName(name)
+	if filepath.Ext(name) == "" {
+		name += extensionForType(info.ContentType)
+	}
+	return name
+}
+
+// nameFromURL returns the last path segment, ignoring any query string.
+func nameFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return "index"
+	}
+	return name
+}
+
+// nameFromDisposition reads filename (or the RFC 2231 filename*) from a
+// Content-Disposition header.
+func nameFromDisposition(header string) string {
+	if header == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
+
+func extensionForType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "application/octet-stream" {
+		return ""
+	}
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// sanitizeName turns an untrusted name into a single safe path element:
+// no directories, no characters Windows or shells choke on, no hidden
+// dot files and no overlong names.
+func sanitizeName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = path.Base(name)
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			return -1
+		case strings.ContainsRune(`<>:"/\|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+
+	if len(name) > maxNameLength {
+		ext := filepath.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		name = strings.ToValidUTF8(name[:maxNameLength-len(ext)], "") + ext
+	}
+	if name == "" {
+		return "download"
+	}
+	return name
+}
+
+// claimPath reserves a path in dir for name according to the collision
+// policy. ok is false when the download should be skipped. Claimed paths
+// must be handed back with releasePath.
+func (d *Downloader) claimPath(dir, name string) (filePath string, ok bool) {
+	d.namesMu.Lock()
+	defer d.namesMu.Unlock()
+
+	filePath = filepath.Join(dir, name)
+	if d.pathFreeLocked(filePath) {
+		d.claimed[filePath] = name
+		return filePath, true
+	}
+
+	switch {
+	case d.collisionPolicy == CollisionSkip:
+		return filePath, false
+	case d.collisionPolicy == CollisionOverwrite && d.claimed[filePath] == "":
+		d.claimed[filePath] = name
+		return filePath, true
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		filePath = filepath.Join(dir, fmt.Sprintf("%s-%d%s", base, i, ext))
+		if d.pathFreeLocked(filePath) {
+			d.claimed[filePath] = name
+			return filePath, true
+		}
+	}
+}
+
+// pathFreeLocked reports whether nobody is using filePath. A leftover
+// partial file doesn't count: it is ours to resume.
+func (d *Downloader) pathFreeLocked(filePath string) bool {
+	if d.claimed[filePath] != "" {
+		return false
+	}
+	_, err := os.Lstat(filePath)
+	return os.IsNotExist(err)
+}
+
+// claimExisting reserves filePath, saved earlier for a download that asks
+// for name, for overwriting, as update mode does, unless another download
+// is already writing it.
+func (d *Downloader) claimExisting(filePath, name string) bool {
+	d.namesMu.Lock()
+	defer d.namesMu.Unlock()
+	if d.claimed[filePath] != "" {
+		return false
+	}
+	d.claimed[filePath] = name
+	return true
+}
+
+// requestedName returns the name the download holding filePath asked for,
+// before any collision renaming.
+func (d *Downloader) requestedName(filePath string) string {
+	d.namesMu.Lock()
+	defer d.namesMu.Unlock()
+	if name := d.claimed[filePath]; name != "" {
+		return name
+	}
+	return filepath.Base(filePath)
+}
+
+func (d *Downloader) releasePath(filePath string) {
+	d.namesMu.Lock()
+	defer d.namesMu.Unlock()
+	delete(d.claimed, filePath)
+}
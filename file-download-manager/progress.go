@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Progress describes one download, or all of them in ProgressReport.Total.
+// Total is -1 while the size is unknown, and ETA is 0 when it can't be
+// estimated.
+type Progress struct {
+	URL      string
+	Done     int64
+	Total    int64
+	Speed    float64 // bytes per second, smoothed
+	ETA      time.Duration
+	Finished bool
+	Err      error
+}
+
+type ProgressReport struct {
+	Downloads []Progress
+	Total     Progress
+}
+
+// transfer counts the bytes of one download. Writers bump done atomically,
+// so counting never blocks the copy loop.
+type transfer struct {
+	url   string
+	done  atomic.Int64
+	total atomic.Int64
+
+	// Sampling state, guarded by ProgressTracker.mu.
+	finished   bool
+	err        error
+	speed      float64
+	lastDone   int64
+	lastSample time.Time
+}
+
+// writer wraps dst so everything written to it counts towards the transfer.
+func (t *transfer) writer(dst io.Writer) io.Writer {
+	return &countingWriter{dst: dst, t: t}
+}
+
+type countingWriter struct {
+	dst io.Writer
+	t   *transfer
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.dst.Write(p)
+	w.t.done.Add(int64(n))
+	return n, err
+}
+
+// writerAt is writer for segments writing at offsets into a shared file.
+func (t *transfer) writerAt(dst io.WriterAt) io.WriterAt {
+	return &countingWriterAt{dst: dst, t: t}
+}
+
+type countingWriterAt struct {
+	dst io.WriterAt
+	t   *transfer
+}
+
+func (w *countingWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	n, err := w.dst.WriteAt(p, off)
+	w.t.done.Add(int64(n))
+	return n, err
+}
+
+// ProgressTracker records every download a Downloader runs and turns the
+// byte counts into speed and ETA when sampled.
+type ProgressTracker struct {
+	mu        sync.Mutex
+	transfers []*transfer
+	byURL     map[string]*transfer
+	speed     float64
+	lastDone  int64
+	lastTick  time.Time
+}
+
+func NewProgressTracker() *ProgressTracker {
+	return &ProgressTracker{byURL: make(map[string]*transfer)}
+}
+
+// start registers (or restarts) the transfer for url. total is -1 if unknown.
+func (p *ProgressTracker) start(url string, done, total int64) *transfer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t, ok := p.byURL[url]
+	if !ok {
+		t = &transfer{url: url}
+		p.byURL[url] = t
+		p.transfers = append(p.transfers, t)
+	}
+	t.done.Store(done)
+	t.total.Store(total)
+	t.finished = false
+	t.err = nil
+	t.lastDone = done
+	t.lastSample = time.Now()
+	return t
+}
+
+// finish marks the transfer for url as done, successfully or not.
+func (p *ProgressTracker) finish(url string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t, ok := p.byURL[url]
+	if !ok {
+		t = &transfer{url: url}
+		t.total.Store(-1)
+		p.byURL[url] = t
+		p.transfers = append(p.transfers, t)
+	}
+	t.finished = true
+	t.err = err
+	if err == nil && t.total.Load() < 0 {
+		t.total.Store(t.done.Load())
+	}
+}
+
+// Snapshot samples every download and the aggregate. Speeds are smoothed
+// between calls, so call it at a steady interval (Watch does).
+func (p *ProgressTracker) Snapshot() ProgressReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	report := ProgressReport{Downloads: make([]Progress, 0, len(p.transfers))}
+	report.Total = Progress{URL: "total"}
+	knownTotal := true
+	allFinished := true
+
+	for _, t := range p.transfers {
+		done, total := t.done.Load(), t.total.Load()
+		if !t.finished {
+			t.speed = smoothSpeed(t.speed, done-t.lastDone, now.Sub(t.lastSample))
+		} else {
+			t.speed = 0
+		}
+		t.lastDone, t.lastSample = done, now
+
+		report.Downloads = append(report.Downloads, Progress{
+			URL:      t.url,
+			Done:     done,
+			Total:    total,
+			Speed:    t.speed,
+			ETA:      eta(done, total, t.speed),
+			Finished: t.finished,
+			Err:      t.err,
+		})
+
+		report.Total.Done += done
+		if total < 0 {
+			knownTotal = false
+		} else {
+			report.Total.Total += total
+		}
+		allFinished = allFinished && t.finished
+	}
+
+	if !p.lastTick.IsZero() {
+		p.speed = smoothSpeed(p.speed, report.Total.Done-p.lastDone, now.Sub(p.lastTick))
+	}
+	p.lastDone, p.lastTick = report.Total.Done, now
+
+	if !knownTotal {
+		report.Total.Total = -1
+	}
+	report.Total.Speed = p.speed
+	report.Total.ETA = eta(report.Total.Done, report.Total.Total, p.speed)
+	report.Total.Finished = allFinished
+	return report
+}
+
+// Watch calls fn with a fresh snapshot every interval until the returned
+// function is called, which also delivers one last snapshot.
+func (p *ProgressTracker) Watch(interval time.Duration, fn func(ProgressReport)) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				fn(p.Snapshot())
+				return
+			case <-ticker.C:
+				fn(p.Snapshot())
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+// Updates returns a channel fed with snapshots every interval, for callers
+// that prefer channels to callbacks. Snapshots a slow reader hasn't taken
+// yet are replaced by newer ones.
+func (p *ProgressTracker) Updates(interval time.Duration) (<-chan ProgressReport, func()) {
+	ch := make(chan ProgressReport, 1)
+	stop := p.Watch(interval, func(report ProgressReport) {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- report
+	})
+	return ch, func() {
+		stop()
+		close(ch)
+	}
+}
+
+// smoothSpeed folds the bytes seen over elapsed into an exponentially
+// weighted average, so the rate doesn't jump around with every sample.
+func smoothSpeed(previous float64, bytes int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return previous
+	}
+	current := float64(bytes) / elapsed.Seconds()
+	if previous == 0 {
+		return current
+	}
+	return 0.3*current + 0.7*previous
+}
+
+func eta(done, total int64, speed float64) time.Duration {
+	if total < 0 || speed <= 0 || done >= total {
+		return 0
+	}
+	return time.Duration(float64(total-done) / speed * float64(time.Second))
+}
+
+// TerminalRenderer draws one live line per active download plus a total
+// line, redrawing in place with ANSI escape codes.
+type TerminalRenderer struct {
+	mu    sync.Mutex
+	out   io.Writer
+	lines int // lines drawn last time, to move the cursor back over
+}
+
+func NewTerminalRenderer(out io.Writer) *TerminalRenderer {
+	return &TerminalRenderer{out: out}
+}
+
+// Render redraws the live block from report.
+func (r *TerminalRenderer) Render(report ProgressReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b strings.Builder
+	r.clearLocked(&b)
+	for _, p := range report.Downloads {
+		if p.Finished {
+			continue
+		}
+		b.WriteString(formatProgress(p))
+		b.WriteByte('\n')
+		r.lines++
+	}
+	if len(report.Downloads) > 0 {
+		b.WriteString(formatProgress(report.Total))
+		b.WriteByte('\n')
+		r.lines++
+	}
+	io.WriteString(r.out, b.String())
+}
+
+// Logf prints a message above the live block without garbling it; the
+// block is redrawn on the next Render.
+func (r *TerminalRenderer) Logf(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b strings.Builder
+	r.clearLocked(&b)
+	fmt.Fprintf(&b, format, args...)
+	io.WriteString(r.out, b.String())
+}
+
+// clearLocked moves the cursor to the top of the live block and erases it.
+func (r *TerminalRenderer) clearLocked(b *strings.Builder) {
+	if r.lines > 0 {
+		fmt.Fprintf(b, "\033[%dA\033[J", r.lines)
+	}
+	r.lines = 0
+}
+
+func formatProgress(p Progress) string {
+	name := redactURL(p.URL)
+	if len(name) > 40 {
+		name = "..." + name[len(name)-37:]
+	}
+
+	size := formatBytes(p.Done)
+	percent := ""
+	if p.Total >= 0 {
+		size += " / " + formatBytes(p.Total)
+		if p.Total > 0 {
+			percent = fmt.Sprintf("%5.1f%%", float64(p.Done)*100/float64(p.Total))
+		}
+	}
+
+	line := fmt.Sprintf("%-40s %6s %-21s %9s/s", name, percent, size, formatBytes(int64(p.Speed)))
+	if p.ETA > 0 {
+		line += " ETA " + p.ETA.Round(time.Second).String()
+	}
+	return line
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
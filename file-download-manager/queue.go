@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrUnknownJob = errors.New("no such download in the queue")
+
+type JobState string
+
+const (
+	JobQueued JobState = "queued"
+	JobActive JobState = "active"
+	JobPaused JobState = "paused"
+	JobDone   JobState = "done"
+	JobFailed JobState = "failed"
+)
+
+// QueueItem is one download in the queue. Higher Priority runs first;
+// equal priorities run in the order they were added.
+type QueueItem struct {
+	ID       string            `json:"id"`
+	URL      string            `json:"url"`
+	Mirrors  []string          `json:"mirrors,omitempty"`
+	Output   string            `json:"output,omitempty"`
+	Checksum string            `json:"checksum,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Priority int               `json:"priority"`
+	State    JobState          `json:"state"`
+	Error    string            `json:"error,omitempty"`
+	Added    time.Time         `json:"added"`
+}
+
+func (item *QueueItem) entry() Entry {
+	return Entry{
+		URL:      item.URL,
+		Mirrors:  item.Mirrors,
+		Output:   item.Output,
+		Checksum: item.Checksum,
+		Headers:  item.Headers,
+		Priority: item.Priority,
+	}
+}
+
+type queueFile struct {
+	NextID int          `json:"next_id"`
+	Items  []*QueueItem `json:"items"`
+}
+
+// Queue runs downloads a few at a time and saves its state to disk after
+// every change, so a restarted process picks up where it stopped.
+type Queue struct {
+	mu          sync.Mutex
+	path        string
+	downloader  *Downloader
+	concurrency int
+	nextID      int
+	items       map[string]*QueueItem
+	cancels     map[string]context.CancelFunc // until each download's goroutine exits
+	changed     chan struct{}
+	ctx         context.Context
+	stop        context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+// OpenQueue loads the queue saved at path, or starts an empty one.
+// Downloads that were active when the process stopped are queued again and
+// resume from their partial files.
+func OpenQueue(path string, downloader *Downloader, concurrency int) (*Queue, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	q := &Queue{
+		path:        path,
+		downloader:  downloader,
+		concurrency: concurrency,
+		items:       make(map[string]*QueueItem),
+		cancels:     make(map[string]context.CancelFunc),
+		changed:     make(chan struct{}, 1),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read queue %s: %s", path, err)
+	}
+
+	var saved queueFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("corrupt queue file %s: %s", path, err)
+	}
+	q.nextID = saved.NextID
+	for _, item := range saved.Items {
+		if item.State == JobActive {
+			item.State = JobQueued
+		}
+		q.items[item.ID] = item
+	}
+	return q, nil
+}
+
+// SetConcurrency changes how many downloads may run at once.
+func (q *Queue) SetConcurrency(n int) {
+	q.mu.Lock()
+	if n < 1 {
+		n = 1
+	}
+	q.concurrency = n
+	q.mu.Unlock()
+	q.notify()
+}
+
+// Add queues url and returns its id.
+func (q *Queue) Add(url string, priority int) (string, error) {
+	return q.AddEntry(Entry{URL: url, Priority: priority})
+}
+
+// AddEntry queues a download along with its settings and returns its id.
+func (q *Queue) AddEntry(entry Entry) (string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.nextID++
+	item := &QueueItem{
+		ID:       strconv.Itoa(q.nextID),
+		URL:      entry.URL,
+		Mirrors:  entry.Mirrors,
+		Output:   entry.Output,
+		Checksum: entry.Checksum,
+		Headers:  entry.Headers,
+		Priority: entry.Priority,
+		State:    JobQueued,
+		Added:    time.Now(),
+	}
+	q.items[item.ID] = item
+	if err := q.saveLocked(); err != nil {
+		return "", err
+	}
+	q.notify()
+	return item.ID, nil
+}
+
+// Remove drops a download from the queue, stopping it if it is running.
+func (q *Queue) Remove(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.items[id]; !ok {
+		return ErrUnknownJob
+	}
+	if cancel, ok := q.cancels[id]; ok {
+		cancel()
+	}
+	delete(q.items, id)
+	return q.saveLocked()
+}
+
+// Pause stops a queued or active download until Resume is called. An
+// active download keeps its partial file and continues from it later.
+func (q *Queue) Pause(id string) error {
+	return q.update(id, func(item *QueueItem) {
+		if item.State != JobQueued && item.State != JobActive {
+			return
+		}
+		if cancel, ok := q.cancels[id]; ok {
+			cancel()
+		}
+		item.State = JobPaused
+	})
+}
+
+// Requeue queues a finished or failed download again with entry's
+// settings, so asking for the same URL reuses its item. A waiting item
+// only takes entry's URL and mirrors, which may carry passwords the queue
+// file leaves out.
+func (q *Queue) Requeue(id string, entry Entry) error {
+	return q.update(id, func(item *QueueItem) {
+		item.URL = entry.URL
+		item.Mirrors = entry.Mirrors
+		if item.State != JobDone && item.State != JobFailed {
+			return
+		}
+		item.Output = entry.Output
+		item.Checksum = entry.Checksum
+		item.Headers = entry.Headers
+		item.Priority = entry.Priority
+		item.State = JobQueued
+		item.Error = ""
+	})
+}
+
+// Resume queues a paused or failed download again.
+func (q *Queue) Resume(id string) error {
+	return q.update(id, func(item *QueueItem) {
+		if item.State == JobPaused || item.State == JobFailed {
+			item.State = JobQueued
+			item.Error = ""
+		}
+	})
+}
+
+// SetPriority changes where a waiting download sits in the queue.
+func (q *Queue) SetPriority(id string, priority int) error {
+	return q.update(id, func(item *QueueItem) {
+		item.Priority = priority
+	})
+}
+
+// List returns a copy of every item, in the order they would run.
+func (q *Queue) List() []QueueItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := make([]QueueItem, 0, len(q.items))
+	for _, item := range q.sortedLocked() {
+		items = append(items, *item)
+	}
+	return items
+}
+
+func (q *Queue) update(id string, fn func(*QueueItem)) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item, ok := q.items[id]
+	if !ok {
+		return ErrUnknownJob
+	}
+	fn(item)
+	if err := q.saveLocked(); err != nil {
+		return err
+	}
+	q.notify()
+	return nil
+}
+
+// Start runs the scheduler until ctx is cancelled or Stop is called.
+func (q *Queue) Start(ctx context.Context) {
+	q.mu.Lock()
+	q.ctx, q.stop = context.WithCancel(ctx)
+	q.mu.Unlock()
+
+	q.wg.Add(1)
+	go q.schedule()
+}
+
+// Stop cancels active downloads, leaving them queued for the next run, and
+// waits for them to wind down.
+func (q *Queue) Stop() {
+	q.mu.Lock()
+	if q.stop != nil {
+		q.stop()
+	}
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+// WaitIdle blocks until nothing is queued or running, or ctx is done.
+// Paused downloads don't count as pending.
+func (q *Queue) WaitIdle(ctx context.Context) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		q.mu.Lock()
+		pending := false
+		for _, item := range q.items {
+			if item.State == JobQueued || item.State == JobActive {
+				pending = true
+				break
+			}
+		}
+		q.mu.Unlock()
+		if !pending {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) schedule() {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		q.startReadyLocked()
+		ctx := q.ctx
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.changed:
+		}
+	}
+}
+
+// startReadyLocked starts the best queued items until the concurrency
+// limit is reached.
+func (q *Queue) startReadyLocked() {
+	if q.ctx.Err() != nil {
+		return
+	}
+	for _, item := range q.sortedLocked() {
+		if len(q.cancels) >= q.concurrency {
+			return
+		}
+		// A download resumed right after a pause may still be winding down.
+		if _, running := q.cancels[item.ID]; running || item.State != JobQueued {
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(q.ctx)
+		q.cancels[item.ID] = cancel
+		item.State = JobActive
+		q.saveLocked()
+
+		q.wg.Add(1)
+		go q.run(ctx, item.ID, item.entry())
+	}
+}
+
+func (q *Queue) run(ctx context.Context, id string, entry Entry) {
+	defer q.wg.Done()
+	err := q.downloader.Apply(entry)
+	if err == nil {
+		err = q.downloader.Download(ctx, entry.URL)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	defer q.notify()
+	interrupted := ctx.Err() != nil
+	q.cancels[id]()
+	delete(q.cancels, id)
+
+	item, ok := q.items[id]
+	if !ok {
+		return // removed while running
+	}
+	if interrupted {
+		// Paused, removed or shutting down: Pause already set the state,
+		// and on shutdown the item stays queued for the next run.
+		if item.State == JobActive {
+			item.State = JobQueued
+		}
+		q.saveLocked()
+		return
+	}
+
+	if err != nil {
+		item.State = JobFailed
+		item.Error = err.Error()
+	} else {
+		item.State = JobDone
+	}
+	q.saveLocked()
+}
+
+func (q *Queue) sortedLocked() []*QueueItem {
+	items := make([]*QueueItem, 0, len(q.items))
+	for _, item := range q.items {
+		items = append(items, item)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Priority != items[j].Priority {
+			return items[i].Priority > items[j].Priority
+		}
+		return items[i].Added.Before(items[j].Added)
+	})
+	return items
+}
+
+// saveLocked writes the queue through a temp file and rename so a crash
+// mid-write never leaves a truncated queue behind. Items may carry headers
+// with credentials, so only the owner can read the file, and passwords in
+// URLs are left out altogether: a download that needs one gets it from the
+// URL being passed again, or from -hosts or .netrc.
+func (q *Queue) saveLocked() error {
+	if err := os.MkdirAll(filepath.Dir(q.path), 0755); err != nil {
+		return fmt.Errorf("unable to create queue dir: %s", err)
+	}
+	items := q.sortedLocked()
+	saved := make([]*QueueItem, len(items))
+	for i, item := range items {
+		copied := *item
+		copied.URL = stripPassword(item.URL)
+		copied.Mirrors = make([]string, len(item.Mirrors))
+		for j, mirror := range item.Mirrors {
+			copied.Mirrors[j] = stripPassword(mirror)
+		}
+		saved[i] = &copied
+	}
+	data, err := json.MarshalIndent(queueFile{NextID: q.nextID, Items: saved}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("unable to save queue: %s", err)
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		return fmt.Errorf("unable to save queue: %s", err)
+	}
+	return nil
+}
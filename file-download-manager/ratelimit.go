@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket measured in bytes. A rate of zero or less
+// means unlimited. The rate can be changed while downloads are running.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	l := &RateLimiter{last: time.Now()}
+	l.SetRate(bytesPerSecond)
+	return l
+}
+
+// SetRate changes the limit; zero or less removes it.
+func (l *RateLimiter) SetRate(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refillLocked(time.Now())
+	l.rate = float64(bytesPerSecond)
+	l.tokens = min(l.tokens, l.burstLocked())
+}
+
+// Rate returns the current limit in bytes per second, 0 if unlimited.
+func (l *RateLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	return int64(l.rate)
+}
+
+// burst is the bucket size: a tenth of a second's worth keeps the output
+// smooth, with a floor so tiny limits still make progress.
+func (l *RateLimiter) burst() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return int(^uint(0) >> 1)
+	}
+	return int(l.burstLocked())
+}
+
+func (l *RateLimiter) burstLocked() float64 {
+	return max(l.rate/10, 512)
+}
+
+func (l *RateLimiter) refillLocked(now time.Time) {
+	if l.rate > 0 {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.burstLocked())
+	}
+	l.last = now
+}
+
+// WaitN blocks until n bytes may pass. n should not exceed burst.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.refillLocked(now)
+		if l.rate <= 0 {
+			l.mu.Unlock()
+			return nil
+		}
+		need := min(float64(n), l.burstLocked())
+		if l.tokens >= need {
+			l.tokens -= need
+			l.mu.Unlock()
+			return nil
+		}
+		// Sleep in short steps so a raised limit takes effect promptly.
+		wait := time.Duration((need - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		timer := time.NewTimer(min(wait, 100*time.Millisecond))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// throttledReader reads in chunks no bigger than any limiter's burst and
+// waits on every limiter before handing the bytes on.
+type throttledReader struct {
+	ctx      context.Context
+	src      io.Reader
+	limiters []*RateLimiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	for _, l := range r.limiters {
+		if b := l.burst(); len(p) > b {
+			p = p[:b]
+		}
+	}
+	n, err := r.src.Read(p)
+	if n > 0 {
+		for _, l := range r.limiters {
+			if waitErr := l.WaitN(r.ctx, n); waitErr != nil {
+				return n, waitErr
+			}
+		}
+	}
+	return n, err
+}
+
+// SetGlobalLimit caps the combined throughput of all downloads.
+func (d *Downloader) SetGlobalLimit(bytesPerSecond int64) {
+	d.globalLimit.SetRate(bytesPerSecond)
+}
+
+// SetDefaultDownloadLimit caps each download that has no limit of its own.
+func (d *Downloader) SetDefaultDownloadLimit(bytesPerSecond int64) {
+	d.limitMu.Lock()
+	defer d.limitMu.Unlock()
+	d.defaultLimit = bytesPerSecond
+	for url, l := range d.downloadLimits {
+		if !d.customLimits[url] {
+			l.SetRate(bytesPerSecond)
+		}
+	}
+}
+
+// SetDownloadLimit caps a single download, including one already running.
+func (d *Downloader) SetDownloadLimit(url string, bytesPerSecond int64) {
+	d.limitMu.Lock()
+	defer d.limitMu.Unlock()
+	d.customLimits[url] = true
+	if l, ok := d.downloadLimits[url]; ok {
+		l.SetRate(bytesPerSecond)
+		return
+	}
+	d.downloadLimits[url] = NewRateLimiter(bytesPerSecond)
+}
+
+// throttle wraps a response body so reading it respects both the global
+// limit and url's own limit. Segments of one download share its limiter.
+func (d *Downloader) throttle(ctx context.Context, url string, body io.Reader) io.Reader {
+	d.limitMu.Lock()
+	l, ok := d.downloadLimits[url]
+	if !ok {
+		l = NewRateLimiter(d.defaultLimit)
+		d.downloadLimits[url] = l
+	}
+	d.limitMu.Unlock()
+
+	return &throttledReader{ctx: ctx, src: body, limiters: []*RateLimiter{d.globalLimit, l}}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+type ResultStatus string
+
+const (
+	ResultOK          ResultStatus = "ok"
+	ResultFailed      ResultStatus = "failed"
+	ResultInterrupted ResultStatus = "interrupted"
+	ResultSkipped     ResultStatus = "skipped"
+	ResultUnchanged   ResultStatus = "unchanged"
+)
+
+// Result is the outcome of the latest attempt at one download.
+type Result struct {
+	URL      string        `json:"url"`
+	Status   ResultStatus  `json:"status"`
+	Path     string        `json:"path,omitempty"`
+	Size     int64         `json:"size"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+	Finished time.Time     `json:"finished"`
+}
+
+// Results collects outcomes as downloads finish. A URL downloaded again
+// (after a pause or retry) replaces its earlier result.
+type Results struct {
+	mu    sync.Mutex
+	byURL map[string]*Result
+	order []string
+}
+
+func NewResults() *Results {
+	return &Results{byURL: make(map[string]*Result)}
+}
+
+func (r *Results) record(result Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byURL[result.URL]; !ok {
+		r.order = append(r.order, result.URL)
+	}
+	r.byURL[result.URL] = &result
+}
+
+// List returns every result in the order the downloads first finished.
+func (r *Results) List() []Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]Result, 0, len(r.order))
+	for _, url := range r.order {
+		list = append(list, *r.byURL[url])
+	}
+	return list
+}
+
+// Failed reports whether any download ended in failure.
+func (r *Results) Failed() bool {
+	for _, result := range r.List() {
+		if result.Status == ResultFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// newResult builds the Result for a finished Download call, keeping any
+// password in the URL out of it.
+func newResult(url, filePath string, started time.Time, interrupted bool, err error) Result {
+	result := Result{
+		URL:      redactURL(url),
+		Path:     filePath,
+		Duration: time.Since(started),
+		Finished: time.Now(),
+	}
+	switch {
+	case err == nil:
+		result.Status = ResultOK
+		result.Size = diskUsage(filePath)
+	case interrupted:
+		result.Status = ResultInterrupted
+		result.Error = err.Error()
+		result.Path = ""
+	default:
+		result.Status = ResultFailed
+		result.Error = err.Error()
+		result.Path = ""
+	}
+	return result
+}
+
+// diskUsage is the size of a file, or of everything in a directory such as
+// an extracted archive.
+func diskUsage(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// WriteSummary prints a table of every result followed by the totals.
+func (r *Results) WriteSummary(w io.Writer) {
+	list := r.List()
+	sort.SliceStable(list, func(i, j int) bool {
+		// Failures last, where they are easiest to spot.
+		return list[i].Status == ResultOK && list[j].Status != ResultOK
+	})
+
+	var ok, unchanged, skipped, failed, interrupted int
+	var bytes int64
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, result := range list {
+		switch result.Status {
+		case ResultOK:
+			ok++
+			bytes += result.Size
+			fmt.Fprintf(tw, "OK\t%s\t%s\t%s\t%s\n", result.URL, formatBytes(result.Size),
+				result.Duration.Round(time.Millisecond), result.Path)
+		case ResultUnchanged:
+			unchanged++
+			fmt.Fprintf(tw, "UNCHANGED\t%s\t%s\t\t%s\n", result.URL, formatBytes(result.Size), result.Path)
+		case ResultSkipped:
+			skipped++
+			fmt.Fprintf(tw, "SKIPPED\t%s\t\t\t%s already exists\n", result.URL, result.Path)
+		case ResultInterrupted:
+			interrupted++
+			fmt.Fprintf(tw, "INTERRUPTED\t%s\t\t%s\t%s\n", result.URL,
+				result.Duration.Round(time.Millisecond), result.Error)
+		default:
+			failed++
+			fmt.Fprintf(tw, "FAILED\t%s\t\t%s\t%s\n", result.URL,
+				result.Duration.Round(time.Millisecond), result.Error)
+		}
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d downloaded (%s), %d unchanged, %d skipped, %d failed, %d interrupted\n",
+		ok, formatBytes(bytes), unchanged, skipped, failed, interrupted)
+}
+
+// WriteJSON saves every result to path as a JSON array.
+func (r *Results) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r.List(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("unable to write report %s: %s", path, err)
+	}
+	return nil
+}
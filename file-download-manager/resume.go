@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// partialMeta is stored next to a .part file so an interrupted download can
+// be resumed later, as long as the server still serves the same content.
+// URLs are stored without passwords.
+type partialMeta struct {
+	URL          string `json:"url"`
+	Source       string `json:"source,omitempty"` // mirror the validators came from, if not URL
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	TotalSize    int64  `json:"total_size,omitempty"`
+	Received     int64  `json:"received"`
+	// Segments is set for a segmented download, whose bytes arrive out of
+	// order: the .part file is preallocated and each segment records how
+	// far it got.
+	Segments []segmentProgress `json:"segments,omitempty"`
+}
+
+// segmentProgress is one byte range of a segmented download.
+type segmentProgress struct {
+	Next int64 `json:"next"` // first byte not yet written
+	End  int64 `json:"end"`  // last byte, inclusive
+}
+
+// splitSegments divides bytes start..size-1 into at most n ranges.
+func splitSegments(start, size int64, n int) []segmentProgress {
+	var segments []segmentProgress
+	segmentSize := (size - start + int64(n) - 1) / int64(n)
+	for ; start < size; start += segmentSize {
+		segments = append(segments, segmentProgress{Next: start, End: min(start+segmentSize, size) - 1})
+	}
+	return segments
+}
+
+// segmentsDone counts the bytes segments have written.
+func (m *partialMeta) segmentsDone() int64 {
+	done := m.TotalSize
+	for _, segment := range m.Segments {
+		done -= segment.End - segment.Next + 1
+	}
+	return done
+}
+
+func metaPath(partPath string) string {
+	return partPath + ".json"
+}
+
+// loadPartial returns the metadata and current size of a partial download,
+// or nil if there is nothing that can be resumed for url.
+func loadPartial(partPath, url string) (*partialMeta, int64) {
+	data, err := os.ReadFile(metaPath(partPath))
+	if err != nil {
+		return nil, 0
+	}
+	var meta partialMeta
+	if err := json.Unmarshal(data, &meta); err != nil || meta.URL != stripPassword(url) {
+		return nil, 0
+	}
+	// Without a validator we can't tell whether the remote file changed.
+	if meta.ETag == "" && meta.LastModified == "" {
+		return nil, 0
+	}
+
+	info, err := os.Stat(partPath)
+	if err != nil || info.Size() == 0 {
+		return nil, 0
+	}
+	// The file on disk is the source of truth; the recorded count may be
+	// stale if the process was killed mid-write.
+	return &meta, info.Size()
+}
+
+func savePartial(partPath string, meta *partialMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(metaPath(partPath), data, 0644)
+}
+
+func removePartial(partPath string) {
+	os.Remove(partPath)
+	os.Remove(metaPath(partPath))
+}
+
+// from reports whether the partial data came from source.
+func (m *partialMeta) from(source string) bool {
+	source = stripPassword(source)
+	if m.Source == "" {
+		return m.URL == source
+	}
+	return m.Source == source
+}
+
+// validator is what a resumed request sends as If-Range: the ETag unless
+// it is weak, else Last-Modified.
+func (m *partialMeta) validator() string {
+	if m.ETag != "" && !strings.HasPrefix(m.ETag, "W/") {
+		return m.ETag
+	}
+	return m.LastModified
+}
+
+// setResumeHeaders asks for the rest of the file, but only if it is still the
+// version we started with; otherwise If-Range makes the server send it whole.
+// Without a validator, as when switching mirrors, the caller relies on the
+// total size matching instead.
+func setResumeHeaders(req *http.Request, offset int64, ifRange string) {
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	if ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
+}
+
+// parseContentRange parses "bytes start-end/total", returning -1 for an
+// unknown total.
+func parseContentRange(header string) (start, end, total int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("unsupported Content-Range %q", header)
+	}
+	span, size, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range %q", header)
+	}
+
+	total = -1
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, 0, fmt.Errorf("malformed Content-Range %q", header)
+		}
+	}
+	if span == "*" {
+		return -1, -1, total, nil
+	}
+
+	from, to, ok := strings.Cut(span, "-")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range %q", header)
+	}
+	if start, err = strconv.ParseInt(from, 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range %q", header)
+	}
+	if end, err = strconv.ParseInt(to, 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range %q", header)
+	}
+	return start, end, total, nil
+}
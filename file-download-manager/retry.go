@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// StatusError is an HTTP response the downloader can't use.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration // from the Retry-After header, 0 if absent
+}
+
+func newStatusError(url string, resp *http.Response) *StatusError {
+	return &StatusError{
+		URL:        url,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %s from %s", e.Status, redactURL(e.URL))
+}
+
+// parseRetryAfter accepts both forms of Retry-After: delay seconds or an
+// HTTP date.
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
+// RetryPolicy controls how failed downloads are retried. Delays grow
+// exponentially from BaseDelay up to MaxDelay, with full jitter.
+type RetryPolicy struct {
+	Attempts  int // retries after the first try; 0 disables retrying
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+
+// SetRetryPolicy changes how failed downloads are retried.
+func (d *Downloader) SetRetryPolicy(policy RetryPolicy) {
+	d.retry = policy
+}
+
+// retryable reports whether err is worth another attempt: network errors
+// and timeouts, truncated bodies, 5xx and 429. A non-zero wait is what the
+// server asked for via Retry-After. A request that hit the client's
+// -timeout is retried; the caller's own context ending is checked by
+// withRetries.
+func retryable(err error) (ok bool, wait time.Duration) {
+	if errors.Is(err, context.Canceled) {
+		return false, 0
+	}
+	if errors.Is(err, errStalled) {
+		return true, 0
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.StatusCode
+		return code == http.StatusTooManyRequests || code >= 500, statusErr.RetryAfter
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout, 0
+	}
+	var opErr *net.OpError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &opErr):
+		return true, 0
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return true, 0
+	}
+	// A connection closed before or during the response.
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET), 0
+}
+
+// delay is how long to wait before retry number attempt (counting from 0).
+// A server's Retry-After is honoured up to MaxDelay, so it can't stall a
+// download for hours.
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, p.MaxDelay)
+	}
+	ceiling := p.MaxDelay
+	if attempt < 30 && p.BaseDelay<<attempt < ceiling {
+		ceiling = p.BaseDelay << attempt
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// withRetries runs fetch until it succeeds, fails for good, runs out of
+// attempts or ctx is cancelled. Resumable downloads keep their partial
+// file between attempts, so a retry picks up where the last one stopped.
+func (d *Downloader) withRetries(ctx context.Context, url string, fetch func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fetch()
+		if err == nil || attempt >= d.retry.Attempts || ctx.Err() != nil {
+			return err
+		}
+		ok, retryAfter := retryable(err)
+		if !ok {
+			return err
+		}
+
+		wait := d.retry.delay(attempt, retryAfter)
+		d.logf("Retrying %s in %s (attempt %d of %d): %s\n",
+			redactURL(url), wait.Round(time.Millisecond), attempt+1, d.retry.Attempts, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
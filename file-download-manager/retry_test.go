@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		ok   bool
+		wait time.Duration
+	}{
+		{"canceled", fmt.Errorf("get: %w", context.Canceled), false, 0},
+		{"deadline", fmt.Errorf("get: %w", context.DeadlineExceeded), true, 0},
+		{"stalled", errStalled, true, 0},
+		{"truncated", io.ErrUnexpectedEOF, true, 0},
+		{"503", &StatusError{StatusCode: http.StatusServiceUnavailable, RetryAfter: 2 * time.Second}, true, 2 * time.Second},
+		{"429", &StatusError{StatusCode: http.StatusTooManyRequests}, true, 0},
+		{"404", &StatusError{StatusCode: http.StatusNotFound}, false, 0},
+		{"other", errors.New("bad checksum"), false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, wait := retryable(tt.err)
+			if ok != tt.ok || wait != tt.wait {
+				t.Errorf("retryable(%v) = %v, %s; want %v, %s", tt.err, ok, wait, tt.ok, tt.wait)
+			}
+		})
+	}
+}
+
+func TestDownloadRetriesClientTimeout(t *testing.T) {
+	var gets atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && gets.Add(1) == 1 {
+			// Slower than the client's timeout, once.
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	d := NewDownloader(1, 0)
+	d.logf = t.Logf
+	dir := t.TempDir()
+	d.SetOutputDir(dir)
+	d.SetTimeouts(time.Second, 100*time.Millisecond)
+	d.SetRetryPolicy(RetryPolicy{Attempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	if err := d.Download(context.Background(), server.URL+"/file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if n := gets.Load(); n != 2 {
+		t.Errorf("server saw %d GETs, want 2", n)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "file.txt"))
+	if err != nil || string(data) != "hello" {
+		t.Errorf("file.txt = %q, %v; want hello", data, err)
+	}
+}
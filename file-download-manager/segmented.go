@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const segmentAttempts = 3
+
+var errNoRangeSupport = errors.New("server does not support range requests")
+
+// rangeProbe is what a one byte range request tells us about a file.
+type rangeProbe struct {
+	source       string
+	size         int64
+	validator    string // ETag or Last-Modified, sent as If-Range by every segment
+	etag         string
+	lastModified string
+}
+
+// downloadSegmented splits the file into byte ranges fetched concurrently
+// and written at their offsets into a preallocated file. Servers without
+// range support, or files too small to be worth splitting, go through the
+// regular single stream path instead. A segment whose mirror fails moves
+// on to the next one. When the server gives validators, an interrupted
+// download keeps its .part file and each segment's progress, and the next
+// run picks up where every segment left off.
+func (d *Downloader) downloadSegmented(ctx context.Context, url string, sources []string, filePath, partPath string) error {
+	var probe *rangeProbe
+	err := errNoRangeSupport
+	for i, source := range sources {
+		if !isHTTP(source) {
+			continue
+		}
+		if probe, err = d.probeRanges(ctx, url, source); err == nil {
+			// Segments start with the mirror that answered.
+			sources = append(sources[i:len(sources):len(sources)], sources[:i]...)
+			break
+		}
+	}
+	if err != nil || probe.size < d.minSegmentSize {
+		return d.fetchFromSources(ctx, url, sources, filePath, partPath)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	meta := d.segmentedPartial(partPath, url, probe)
+	if meta != nil {
+		d.logf("Resuming %s at %d of %d bytes\n", redactURL(url), meta.segmentsDone(), probe.size)
+	} else {
+		removePartial(partPath)
+		flags |= os.O_TRUNC
+		meta = &partialMeta{
+			URL:          stripPassword(url),
+			Source:       stripPassword(probe.source),
+			ETag:         probe.etag,
+			LastModified: probe.lastModified,
+			TotalSize:    probe.size,
+			Segments:     splitSegments(0, probe.size, d.segments),
+		}
+	}
+	resumable := probe.validator != ""
+
+	file, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("not able to create the file with filepath %s with err %s", partPath, err)
+	}
+	if err := file.Truncate(probe.size); err != nil {
+		file.Close()
+		removePartial(partPath)
+		return fmt.Errorf("unable to preallocate %d bytes for %s: %s", probe.size, partPath, err)
+	}
+	if resumable {
+		if err := savePartial(partPath, meta); err != nil {
+			file.Close()
+			return fmt.Errorf("not able to create the file with filepath %s with err %s", metaPath(partPath), err)
+		}
+	}
+
+	tracked := d.progress.start(url, meta.segmentsDone(), probe.size)
+	errs := make(chan error, len(meta.Segments))
+	var wg sync.WaitGroup
+	for i := range meta.Segments {
+		segment := &meta.Segments[i]
+		if segment.Next > segment.End {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- d.fetchSegment(ctx, url, sources, probe, tracked.writerAt(file), segment)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	closeErr := file.Sync()
+	if err := file.Close(); closeErr == nil {
+		closeErr = err
+	}
+	if closeErr != nil {
+		removePartial(partPath)
+		return fmt.Errorf("failed to write file: %s", closeErr)
+	}
+	for err := range errs {
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, errNoRangeSupport) {
+			// The file changed or the servers stopped honoring ranges midway.
+			removePartial(partPath)
+			return d.fetchFromSources(ctx, url, sources, filePath, partPath)
+		}
+		if resumable {
+			meta.Received = meta.segmentsDone()
+			savePartial(partPath, meta)
+		} else {
+			os.Remove(partPath)
+		}
+		return err
+	}
+
+	// Segments arrive out of order, so the digest is taken from the file.
+	os.Remove(metaPath(partPath))
+	if err := d.verifyOnDisk(url, partPath, filePath); err != nil {
+		return err
+	}
+	if err := os.Rename(partPath, filePath); err != nil {
+		return fmt.Errorf("failed to move %s into place: %s", partPath, err)
+	}
+	d.finishFile(url, filePath, probe.etag, probe.lastModified)
+	d.logf("File Downloaded from the url:%s in %d segments\n", redactURL(url), len(meta.Segments))
+	return nil
+}
+
+// segmentedPartial returns what an earlier run left in partPath if it can
+// be resumed against probe: the segments of a segmented download, or a
+// single stream partial whose received bytes count as done. The file must
+// still have the same size and validator, as seen from the same mirror.
+func (d *Downloader) segmentedPartial(partPath, url string, probe *rangeProbe) *partialMeta {
+	meta, offset := loadPartial(partPath, url)
+	if meta == nil || probe.validator == "" || meta.validator() != probe.validator ||
+		meta.TotalSize != probe.size || !meta.from(probe.source) {
+		return nil
+	}
+	if meta.Segments == nil {
+		meta.Segments = splitSegments(min(offset, probe.size), probe.size, d.segments)
+	}
+	return meta
+}
+
+// probeRanges asks source for the first byte to learn the file size and
+// whether the server answers range requests.
+func (d *Downloader) probeRanges(ctx context.Context, url, source string) (*rangeProbe, error) {
+	req, err := d.newSourceRequest(ctx, http.MethodGet, url, source)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1))
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, errNoRangeSupport
+	}
+	_, _, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil || total <= 0 {
+		return nil, errNoRangeSupport
+	}
+
+	probe := &rangeProbe{
+		source:       source,
+		size:         total,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}
+	probe.validator = probe.etag
+	if probe.validator == "" || strings.HasPrefix(probe.validator, "W/") {
+		probe.validator = probe.lastModified
+	}
+	return probe, nil
+}
+
+// fetchSegment downloads the rest of segment into file, retrying from
+// where it left off if the connection drops, and moving on to the next
+// mirror if one keeps failing or stalls. segment.Next follows the bytes
+// written.
+func (d *Downloader) fetchSegment(ctx context.Context, url string, sources []string, probe *rangeProbe, file io.WriterAt, segment *segmentProgress) error {
+	var lastErr error
+	for _, source := range sources {
+		if !isHTTP(source) {
+			continue
+		}
+		for attempt := 0; attempt < segmentAttempts; attempt++ {
+			if segment.Next > segment.End {
+				return nil
+			}
+
+			written, err := d.fetchRange(ctx, url, source, probe, file, segment.Next, segment.End)
+			segment.Next += written
+			if err == nil {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			lastErr = err
+			if errors.Is(err, errNoRangeSupport) || errors.Is(err, errStalled) {
+				break
+			}
+		}
+	}
+	if errors.Is(lastErr, errNoRangeSupport) {
+		return lastErr
+	}
+	return fmt.Errorf("segment %d-%d of %s failed after %d attempts: %w", segment.Next, segment.End, redactURL(url), segmentAttempts, lastErr)
+}
+
+// fetchRange asks source for bytes start..end. The probed mirror is held to
+// its validator with If-Range; the others must at least agree on the size.
+func (d *Downloader) fetchRange(ctx context.Context, url, source string, probe *rangeProbe, file io.WriterAt, start, end int64) (int64, error) {
+	ctx, watch := d.watchStalls(ctx)
+	defer watch.stop()
+	req, err := d.newSourceRequest(ctx, http.MethodGet, url, source)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if source == probe.source && probe.validator != "" {
+		req.Header.Set("If-Range", probe.validator)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, watch.check(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, errNoRangeSupport
+	}
+	got, _, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil || got != start || (source != probe.source && total != probe.size) {
+		return 0, errNoRangeSupport
+	}
+
+	writer := io.NewOffsetWriter(file, start)
+	written, err := copyBody(writer, d.throttle(ctx, url, watch.reader(io.LimitReader(resp.Body, end-start+1))))
+	err = watch.check(err)
+	if err == nil && written < end-start+1 {
+		err = io.ErrUnexpectedEOF
+	}
+	return written, err
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSegmentedDownloadResumes(t *testing.T) {
+	content := randomContent(4000)
+	server := newRangeServer(t, content)
+	url := server.URL + "/file.bin"
+
+	// Four segments of 1000 bytes; the second is done and the others got
+	// partway.
+	segmented := make([]byte, 4000)
+	for _, r := range [][2]int{{0, 500}, {1000, 2000}, {2000, 2500}} {
+		copy(segmented[r[0]:r[1]], content[r[0]:r[1]])
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		meta   partialMeta
+		ranges []string
+	}{
+		{
+			name: "segment progress",
+			data: segmented,
+			meta: partialMeta{URL: url, Source: url, ETag: `"v1"`, TotalSize: 4000, Segments: []segmentProgress{
+				{Next: 500, End: 999}, {Next: 1000, End: 999}, {Next: 2500, End: 2999}, {Next: 3000, End: 3999},
+			}},
+			ranges: []string{"bytes=0-0", "bytes=2500-2999", "bytes=3000-3999", "bytes=500-999"},
+		},
+		{
+			name:   "single stream partial",
+			data:   content[:1000],
+			meta:   partialMeta{URL: url, Source: url, ETag: `"v1"`, TotalSize: 4000},
+			ranges: []string{"bytes=0-0", "bytes=1000-1749", "bytes=1750-2499", "bytes=2500-3249", "bytes=3250-3999"},
+		},
+		{
+			name:   "changed file",
+			data:   bytes.Repeat([]byte{'x'}, 4000),
+			meta:   partialMeta{URL: url, Source: url, ETag: `"v0"`, TotalSize: 4000, Segments: []segmentProgress{{Next: 4000, End: 3999}}},
+			ranges: []string{"bytes=0-0", "bytes=0-999", "bytes=1000-1999", "bytes=2000-2999", "bytes=3000-3999"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.mu.Lock()
+			server.ranges = nil
+			server.mu.Unlock()
+
+			dir := t.TempDir()
+			d := NewDownloader(4, 1)
+			d.logf = t.Logf
+			d.SetOutputDir(dir)
+			filePath := filepath.Join(dir, "file.bin")
+			writePartial(t, filePath+".part", tt.data, tt.meta)
+
+			if err := d.Download(context.Background(), url); err != nil {
+				t.Fatal(err)
+			}
+			got := server.Ranges()
+			slices.Sort(got)
+			if !slices.Equal(got, tt.ranges) {
+				t.Errorf("GET ranges = %q, want %q", got, tt.ranges)
+			}
+			data, err := os.ReadFile(filePath)
+			if err != nil || !bytes.Equal(data, content) {
+				t.Errorf("downloaded %d bytes (err %v), want the %d byte original", len(data), err, len(content))
+			}
+		})
+	}
+}
+
+func TestSegmentedDownloadKeepsProgressOnCancel(t *testing.T) {
+	content := randomContent(4000)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("Range") == "bytes=0-0" || r.Method != http.MethodGet {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+			return
+		}
+		// Send half of each segment, then hang until the client gives up.
+		var start, end int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content[start : start+(end-start+1)/2])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	d := NewDownloader(4, 1)
+	d.logf = t.Logf
+	d.SetOutputDir(dir)
+	d.SetRetryPolicy(RetryPolicy{})
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		cancel()
+	}()
+	if err := d.Download(ctx, server.URL+"/file.bin"); err == nil {
+		t.Fatal("cancelled download succeeded")
+	}
+
+	partPath := filepath.Join(dir, "file.bin.part")
+	data, err := os.ReadFile(partPath)
+	if err != nil {
+		t.Fatalf(".part removed on cancel: %s", err)
+	}
+	encoded, err := os.ReadFile(metaPath(partPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta partialMeta
+	if err := json.Unmarshal(encoded, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Segments) != 4 || meta.Received != 2000 {
+		t.Fatalf("saved %d segments with %d bytes received, want 4 with 2000", len(meta.Segments), meta.Received)
+	}
+	for _, segment := range meta.Segments {
+		start := segment.End - 999
+		if segment.Next != start+500 || !bytes.Equal(data[start:segment.Next], content[start:segment.Next]) {
+			t.Errorf("segment ending at %d saved at %d, want %d with its bytes on disk", segment.End, segment.Next, start+500)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+var errStalled = errors.New("download stalled")
+
+const defaultStallTimeout = 30 * time.Second
+
+// SetStallTimeout sets how long a request may go without receiving any
+// data before it is abandoned, so another mirror or attempt can take over.
+// Zero disables stall detection.
+func (d *Downloader) SetStallTimeout(timeout time.Duration) {
+	d.stallTimeout = timeout
+}
+
+// stallWatch cancels a request once no data has arrived for the timeout,
+// counting from when the request is sent.
+type stallWatch struct {
+	timeout time.Duration
+	timer   *time.Timer
+	stalled atomic.Bool
+	cancel  context.CancelFunc
+}
+
+// watchStalls returns a context for one request and the watch guarding it.
+// Call stop once the request is finished with.
+func (d *Downloader) watchStalls(ctx context.Context) (context.Context, *stallWatch) {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &stallWatch{timeout: d.stallTimeout, cancel: cancel}
+	if w.timeout > 0 {
+		w.timer = time.AfterFunc(w.timeout, func() {
+			w.stalled.Store(true)
+			cancel()
+		})
+	}
+	return ctx, w
+}
+
+// reader passes r through, pushing the deadline back whenever data arrives.
+func (w *stallWatch) reader(r io.Reader) io.Reader {
+	if w.timer == nil {
+		return r
+	}
+	return &stallReader{src: r, w: w}
+}
+
+type stallReader struct {
+	src io.Reader
+	w   *stallWatch
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if n > 0 && !r.w.stalled.Load() {
+		r.w.timer.Reset(r.w.timeout)
+	}
+	return n, err
+}
+
+// check turns the cancellation caused by a stall into errStalled, leaving
+// other errors alone.
+func (w *stallWatch) check(err error) error {
+	if err != nil && w.stalled.Load() {
+		return fmt.Errorf("%w: no data for %s", errStalled, w.timeout)
+	}
+	return err
+}
+
+func (w *stallWatch) stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.cancel()
+}